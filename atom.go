@@ -7,18 +7,25 @@ Resources:
 */
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"image"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/ivanizag/iz6502"
 )
 
 const (
-	romStart  = 0xa000
-	ppiaStart = 0xb000
-	viaStart  = 0xb800
+	fdcStart         = 0x0a00
+	videoMemoryStart = 0x8000
+	videoMemorySize  = 0x1800
+	romStart         = 0xa000
+	ppiaStart        = 0xb000
+	viaStart         = 0xb800
 )
 
 type Atom struct {
@@ -26,7 +33,36 @@ type Atom struct {
 	vdu      *mc6847
 	ppia     *ins8255
 	fdc      *fdc8271
+	via      *via6522
 	keyboard *keyboard
+	joystick *joystick
+	speaker  *speaker
+	sounds   driveSounds
+	control  *speedControl
+	memory   memoryMap
+
+	devices     []*attachedDevice // Used only from the emulation goroutine
+	deviceNames []string          // Protected by stateMutex
+	irq         irqLine
+	inputs      []scheduledInput // Sorted by cycle
+	movie       movie
+
+	started       bool // The CPU has been reset on the first execution
+	isDoingReset  bool // BREAK is being pressed
+	frame         uint64
+	frameListener FrameListener
+	listenerMutex sync.Mutex
+
+	haltError error
+	haltMutex sync.Mutex
+
+	// See published.go
+	stateMutex      sync.Mutex
+	running         bool
+	changes         []func()
+	published       videoState
+	publishedIRQ    []InterruptSource
+	publishedDrives [2]DriveStatus
 
 	ram [romStart]uint8
 	rom [0x10000 - romStart]uint8
@@ -35,94 +71,206 @@ type Atom struct {
 	traceIO  bool
 }
 
-func NewAtom() *Atom {
+func NewAtom() (*Atom, error) {
 	var a Atom
 	a.cpu = iz6502.NewNMOS6502(&a)
 	a.vdu = NewMC6847(&a)
 	a.ppia = NewINS8255(&a)
 	a.fdc = NewFDC8271(&a)
-	a.keyboard = newKeyboard()
+	a.via = newVIA6522(&a)
+	a.joystick = newJoystick()
+	a.keyboard = newKeyboard(a.joystick)
+	a.speaker = newSpeaker()
+	a.control = newSpeedControl()
 
-	a.loadRom("akernel.rom", 0xf000)
-	a.loadRom("dosrom.rom", 0xe000)
-	a.loadRom("afloat.rom", 0xd000)
-	a.loadRom("abasic.rom", 0xc000)
-	a.loadRom("Demo.rom", 0xa000)
+	for _, rom := range romSlots {
+		err := a.loadRom(rom.name, rom.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := a.mapMemory()
+	if err != nil {
+		return nil, err
+	}
 
 	//a.traceIO = true
 	//a.traceCPU = true
 	a.cpu.SetTrace(a.traceCPU)
-	return &a
+	return &a, nil
 }
 
-func (a *Atom) LoadDisk(path string) {
-	a.fdc.loadDisk(path)
+// LoadDisk inserts a disk image on the drive 0
+func (a *Atom) LoadDisk(path string) error {
+	return a.LoadDiskWithOptions(path, DiskOptions{})
 }
 
-const (
-	maxWaitDuration = 100 * time.Millisecond
-	cpuSpinLoops    = 100
-	cycleDurationNs = 1000 // 1 MHz
-)
-
-func (a *Atom) Run() {
-	a.cpu.Reset()
-
-	isDoingReset := false
-	referenceTime := time.Now()
+/*
+Run executes the emulation until the context is done. The disk changes
+are saved before returning. An error is returned if the emulation can't
+continue or the disk can't be saved.
+*/
+func (a *Atom) Run(ctx context.Context) (err error) {
+	err = a.start()
+	if err != nil {
+		return err
+	}
+	defer a.stop(&err)
 
 	for {
-		// Keyboard
-		a.keyboard.processKeys()
-		a.fdc.tick(a.cpu.GetCycles())
-
-		// Reset
-		if a.keyboard.getBreak() {
-			if !isDoingReset {
-				a.cpu.Reset()
-				a.ppia.reset()
-				a.fdc.reset()
-				isDoingReset = true
+		// Keyboard, ignored while playing a movie
+		a.keyboard.processKeys(a.liveKey)
+		a.joystick.processButtons(a.liveButton)
+
+		// Pause
+		if a.control.paused.Load() {
+			a.control.needRebase = true
+			a.publish()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pausePollPeriod):
 			}
-		} else {
-			isDoingReset = false
+			continue
 		}
 
-		// Traces
-		pc, _ := a.cpu.GetPCAndSP()
-		if pc == 0xfe66 {
-			// Skip tracing at FE66_wait_for_flyback_start
-			a.cpu.SetTrace(false)
-		} else if pc == 0xfe6b {
-			// Skip tracing at FE6B_wait_for_flyback
-			a.cpu.SetTrace(false)
-		} else if pc == 0xfe70 {
-			// Resume tracing after the flyback wait
-			a.cpu.SetTrace(a.traceCPU)
-		}
-		//a.traceOS()
-
-		// Trace DOS ROM
-		//a.cpu.SetTrace((pc >= 0xe000 && pc <= 0xefff) || pc < 0x100)
-
-		// CPU
-		a.cpu.ExecuteInstruction()
-
-		// Spped control
-		if a.cpu.GetCycles()%cpuSpinLoops == 0 {
-			clockDuration := time.Since(referenceTime)
-			simulatedDuration := time.Duration(float64(a.cpu.GetCycles()) * cycleDurationNs)
-			waitDuration := simulatedDuration - clockDuration
-			if waitDuration > maxWaitDuration || -waitDuration > maxWaitDuration {
-				// We have to wait too long or are too much behind. Let's fast forward
-				referenceTime = referenceTime.Add(-waitDuration)
-				waitDuration = 0
+		if a.step() {
+			if a.Halted() != nil {
+				return
 			}
-			if waitDuration > 0 {
-				time.Sleep(waitDuration)
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
 			}
 		}
 
+		// Speed control
+		cycles := a.cpu.GetCycles()
+		if cycles%cpuSpinLoops == 0 {
+			a.control.throttle(cycles, a.fdc.isBusy(cycles))
+		}
+	}
+}
+
+// Starts the execution of Run, RunCycles or RunFrames
+func (a *Atom) start() error {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	if a.running {
+		return errors.New("the emulation is already running")
+	}
+	if err := a.Halted(); err != nil {
+		return err
+	}
+	a.running = true
+	a.applyChanges()
+	a.capture()
+
+	if !a.started {
+		a.cpu.Reset()
+		a.started = true
+	}
+	return nil
+}
+
+// Ends the execution, to be deferred after start
+func (a *Atom) stop(err *error) {
+	if r := recover(); r != nil {
+		a.halt(fmt.Errorf("%v", r))
+	}
+	a.setRunning(false)
+	*err = a.Halted()
+	errFlush := a.fdc.flush()
+	if *err == nil {
+		*err = errFlush
+	}
+}
+
+// Executes an instruction, returns true if a frame has been completed
+func (a *Atom) step() bool {
+	a.applyInputs(a.cpu.GetCycles())
+	a.fdc.tick(a.cpu.GetCycles())
+
+	// Reset
+	if a.keyboard.getBreak() {
+		if !a.isDoingReset {
+			a.cpu.Reset()
+			a.ppia.reset()
+			a.via.reset()
+			a.fdc.reset()
+			a.resetDevices()
+			a.isDoingReset = true
+		}
+	} else {
+		a.isDoingReset = false
+	}
+
+	// Traces
+	pc, _ := a.cpu.GetPCAndSP()
+	if pc == 0xfe66 {
+		// Skip tracing at FE66_wait_for_flyback_start
+		a.cpu.SetTrace(false)
+	} else if pc == 0xfe6b {
+		// Skip tracing at FE6B_wait_for_flyback
+		a.cpu.SetTrace(false)
+	} else if pc == 0xfe70 {
+		// Resume tracing after the flyback wait
+		a.cpu.SetTrace(a.traceCPU)
+	}
+	//a.traceOS()
+
+	// Trace DOS ROM
+	//a.cpu.SetTrace((pc >= 0xe000 && pc <= 0xefff) || pc < 0x100)
+
+	// CPU
+	a.cpu.ExecuteInstruction()
+	a.tickDevices(a.cpu.GetCycles())
+	a.serviceIRQ()
+
+	// Frame
+	frame := a.cpu.GetCycles() / a.vdu.cyclesPerFrame()
+	if frame != a.frame {
+		a.frame = frame
+		a.endOfFrame(frame * a.vdu.cyclesPerFrame())
+		return true
+	}
+	return false
+}
+
+/*
+FrameListener receives every emulated frame with the audio samples
+generated during it. It is called from the emulation goroutine. The
+image is new for each frame, the samples slice is reused after returning.
+*/
+type FrameListener func(frame *image.RGBA, audio []int16)
+
+// SetFrameListener sets the listener for the frames, nil to remove it
+func (a *Atom) SetFrameListener(listener FrameListener) {
+	a.listenerMutex.Lock()
+	a.frameListener = listener
+	a.listenerMutex.Unlock()
+}
+
+// FrameRate returns the emulated frames per second
+func (a *Atom) FrameRate() int {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	return a.vdu.timing.frameRate
+}
+
+func (a *Atom) endOfFrame(cycle uint64) {
+	a.movieFrame()
+	a.publish()
+	samples := a.speaker.samples(cycle)
+	a.sounds.mix(samples, a.speaker.nextSample-uint64(len(samples)),
+		a.fdc.takeSteps(), a.fdc.isMotorOn())
+
+	a.listenerMutex.Lock()
+	defer a.listenerMutex.Unlock()
+	if a.frameListener != nil {
+		// Only this goroutine updates the published state while running
+		a.frameListener(a.vdu.snapshot(&a.published), samples)
 	}
 }
 
@@ -136,37 +284,65 @@ func (a *Atom) logf(format string, args ...interface{}) {
 //go:embed resources
 var resources embed.FS
 
-func (a *Atom) loadRom(name string, address uint16) {
+var romSlots = []struct {
+	name        string
+	address     uint16
+	description string
+}{
+	{"akernel.rom", 0xf000, "Kernel ROM"},
+	{"dosrom.rom", 0xe000, "DOS ROM"},
+	{"afloat.rom", 0xd000, "Floating point ROM"},
+	{"abasic.rom", 0xc000, "BASIC ROM"},
+	{"Demo.rom", 0xa000, "Utility ROM"},
+}
+
+func (a *Atom) loadRom(name string, address uint16) error {
 	f, err := resources.Open("resources/" + name)
 	if err != nil {
-		panic(err) // Should never happen
+		return err
 	}
+	defer f.Close()
 
 	_, err = f.Read(a.rom[address-romStart:])
 	if err != nil {
-		panic(err) // Should never happen
+		return fmt.Errorf("error loading ROM %v: %w", name, err)
 	}
+	return nil
+}
+
+/*
+halt stops the emulation on an internal fault. The machine stays halted
+with the first error reported until it is created again.
+*/
+func (a *Atom) halt(err error) {
+	a.haltMutex.Lock()
+	defer a.haltMutex.Unlock()
+	if a.haltError == nil {
+		pc, _ := a.cpu.GetPCAndSP()
+		a.haltError = fmt.Errorf("emulation halted at PC 0x%04x: %w", pc, err)
+	}
+}
+
+// Halted returns the error that halted the emulation, nil if not halted
+func (a *Atom) Halted() error {
+	a.haltMutex.Lock()
+	defer a.haltMutex.Unlock()
+	return a.haltError
 }
 
 // Memory interface
 func (a *Atom) Peek(address uint16) uint8 {
-	if address&0xff00 == 0x0a00 {
-		port := uint8(address & 0x07) // 3 bits used
-		return a.fdc.read(port)
-	} else if address < romStart {
-		return a.ram[address]
-	} else if address&0xf800 == ppiaStart {
-		port := uint8(address & 0x03) // 2 bits used
-		value := a.ppia.read(port)
-		//a.logf("[PPIA] Read: %04x, PPIA port%c = 0x%02x\n", address, 'A'+port, value)
-		return value
-	} else if address&0xf800 == viaStart {
-		port := uint8(address & 0x0f) // 4 bits used
-		a.logf("[VIA] Read: %04x, VIA port%c\n", address, 'A'+port)
-		return 0x00
-	} else {
-		return a.rom[address-romStart]
-	}
+	return a.memory.read(address)
+}
+
+/*
+DebugPeek returns the value that Peek would return, without the side
+effects of reading the devices. For debuggers and memory dumps. As Peek,
+it is to be called from the emulation goroutine or when Run is not
+executing.
+*/
+func (a *Atom) DebugPeek(address uint16) uint8 {
+	return a.memory.peek(address)
 }
 
 func (a *Atom) PeekCode(address uint16) uint8 {
@@ -174,25 +350,89 @@ func (a *Atom) PeekCode(address uint16) uint8 {
 }
 
 func (a *Atom) Poke(address uint16, value uint8) {
-	if address&0xff00 == 0x0a00 {
-		port := uint8(address & 0x07) // 3 bits used
-		a.fdc.write(port, value)
-	} else if address < romStart {
-		a.ram[address] = value
-	} else if address&0xf800 == ppiaStart {
-		port := uint8(address & 0x03) // 2 bits used
-		//a.logf("[PPIA] Write: %04x, PPIA port%c = 0x%02x - %08b\n", address, 'A'+port, value, value)
-		a.ppia.write(port, value)
-	} else if address&0xf800 == viaStart {
-		port := uint8(address & 0x0f) // 4 bits used
-		a.logf("[VIA] Write: %04x, VIA port %c = 0x%02x - %08b\n", address, 'A'+port, value, value)
-	}
+	a.memory.write(address, value)
 }
 
+// SendKey presses or releases a key, KEY_*. It doesn't block, the keys are dropped if Run is not executing.
 func (a *Atom) SendKey(key int, released bool) {
 	a.keyboard.sendKey(key, released)
 }
 
+// SendJoystick presses or releases a joystick button, JOYSTICK_*
+func (a *Atom) SendJoystick(button int, released bool) {
+	a.joystick.sendButton(button, released)
+}
+
+// SetJoystickMode selects how the joystick is connected
+func (a *Atom) SetJoystickMode(mode JoystickMode) {
+	a.configure(func() {
+		a.joystick.mode = mode
+	})
+}
+
+// SetJoystickKeys sets the Atom keys pressed by the joystick on JoystickKeys mode
+func (a *Atom) SetJoystickKeys(keys [JOYSTICK_SIZE]int) {
+	a.configure(func() {
+		a.joystick.keys = keys
+	})
+}
+
+// Snapshot returns the image of the last frame completed
 func (a *Atom) Snapshot() *image.RGBA {
-	return a.vdu.snapshot()
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	return a.vdu.snapshot(&a.published)
+}
+
+// VideoMode returns if a graphic mode is selected and the graphic mode, GM0-2, on the last frame
+func (a *Atom) VideoMode() (isGraphic bool, graphicMode uint8) {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	return a.published.mode()
+}
+
+// VideoMemory returns a copy of the video memory at #8000 on the last frame
+func (a *Atom) VideoMemory() []uint8 {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	vram := make([]uint8, videoMemorySize)
+	copy(vram, a.published.vram[:])
+	return vram
+}
+
+// SetVideoStandard selects the NTSC or PAL frame timing
+func (a *Atom) SetVideoStandard(standard VideoStandard) {
+	a.configure(func() {
+		a.vdu.setStandard(standard)
+	})
+}
+
+// LoadCharacterROM loads an external character generator with 8x12 cells
+func (a *Atom) LoadCharacterROM(path string, mode IntExtMode) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = checkCharGen(data)
+	if err != nil {
+		return err
+	}
+	a.configure(func() {
+		a.vdu.loadCharGen(data, mode)
+	})
+	return nil
+}
+
+// SetFontVariant replaces the internal font of the MC6847
+func (a *Atom) SetFontVariant(variant FontVariant) {
+	a.configure(func() {
+		a.vdu.fontVariant = variant
+	})
+}
+
+// SetBorder enables the rendering of the border around the active area.
+func (a *Atom) SetBorder(border bool) {
+	a.configure(func() {
+		a.vdu.border = border
+	})
 }
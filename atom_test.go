@@ -0,0 +1,171 @@
+package izatom
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	bootFrames    = 60
+	keyPressed    = 80_000  // Cycles a key is down
+	keyInterval   = 160_000 // Cycles from a key to the next
+	commandFrames = 60      // For the command to complete after RETURN
+)
+
+// Returns an Atom with the embedded ROMs after the boot
+func bootAtom(t *testing.T) *Atom {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, a, bootFrames)
+	return a
+}
+
+func runFrames(t *testing.T, a *Atom, n int) {
+	t.Helper()
+	err := a.RunFrames(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+Types the lines, a RETURN after each, with the keys scheduled on the
+following cycles, and runs until the last command is done.
+*/
+func typeLines(t *testing.T, a *Atom, lines ...string) {
+	t.Helper()
+	cycle := a.Cycles()
+	press := func(key int, shift bool) {
+		if shift {
+			a.ScheduleInput(Input{Cycle: cycle, Code: KEY_LSHIFT})
+		}
+		a.ScheduleInput(Input{Cycle: cycle, Code: key})
+		a.ScheduleInput(Input{Cycle: cycle + keyPressed, Code: key, Released: true})
+		if shift {
+			a.ScheduleInput(Input{Cycle: cycle + keyPressed, Code: KEY_LSHIFT, Released: true})
+		}
+		cycle += keyInterval
+	}
+
+	for _, line := range lines {
+		for _, ch := range line {
+			key, shift, ok := KeyForChar(ch)
+			if !ok {
+				t.Fatalf("the character %q can't be typed", ch)
+			}
+			press(key, shift)
+		}
+		press(KEY_RETURN, false)
+		err := a.RunCycles(cycle - a.Cycles())
+		if err != nil {
+			t.Fatal(err)
+		}
+		runFrames(t, a, commandFrames)
+		cycle = a.Cycles()
+	}
+}
+
+/*
+Returns the lines printed after the command typed, up to the prompt. The
+prompt can be at the end of the last line, PRINT doesn't add a new line.
+*/
+func commandOutput(t *testing.T, a *Atom, command string) []string {
+	t.Helper()
+	lines := a.ScreenText()
+	for i, line := range lines {
+		if line != ">"+command {
+			continue
+		}
+		var output []string
+		for _, line := range lines[i+1:] {
+			if strings.HasSuffix(line, ">") {
+				if line != ">" {
+					output = append(output, strings.TrimSuffix(line, ">"))
+				}
+				return output
+			}
+			output = append(output, line)
+		}
+		break
+	}
+	t.Fatalf("the output of %q is not on the screen:\n%v", command, strings.Join(lines, "\n"))
+	return nil
+}
+
+func TestBootBanner(t *testing.T) {
+	a := bootAtom(t)
+	lines := a.ScreenText()
+	if lines[0] != "ACORN ATOM" {
+		t.Errorf("the first line is %q, not the banner", lines[0])
+	}
+	if lines[2] != ">" {
+		t.Errorf("there is no prompt:\n%v", strings.Join(lines, "\n"))
+	}
+}
+
+func TestBasicSnippets(t *testing.T) {
+	snippets := []struct {
+		lines  []string
+		output []string
+	}{
+		{[]string{"PRINT 2+3"}, []string{"       5"}},
+		{[]string{"A=6;B=7;PRINT A*B"}, []string{"      42"}},
+		{[]string{"PRINT 7/2, 7%2, -7"}, []string{"       3       1      -7"}},
+		{[]string{"FOR I=1 TO 3;PRINT I;NEXT I"}, []string{"       1       2       3"}},
+		{[]string{"$#2800=\"ATOM\";PRINT $#2800"}, []string{"ATOM"}},
+		{[]string{"10 FOR I=1 TO 2", "20 PRINT \"LOOP\" I'", "30 NEXT I", "40 END", "RUN"},
+			[]string{"LOOP       1", "LOOP       2"}},
+	}
+	for _, s := range snippets {
+		a := bootAtom(t)
+		typeLines(t, a, s.lines...)
+		command := s.lines[len(s.lines)-1]
+		output := commandOutput(t, a, command)
+		if strings.Join(output, "\n") != strings.Join(s.output, "\n") {
+			t.Errorf("%v printed %q, not %q", command, output, s.output)
+		}
+	}
+}
+
+/*
+The frontends call these while Run is executing on another goroutine.
+Run the tests with -race to check the concurrency model.
+*/
+func TestConcurrentAccess(t *testing.T) {
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetWarp(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx)
+	}()
+
+	disk := blankImage(t)
+	standards := []VideoStandard{VideoPAL, VideoNTSC}
+	for i := 0; ctx.Err() == nil; i++ {
+		a.Snapshot()
+		a.VideoMemory()
+		a.DriveStatus(i % 2)
+		a.SetVideoStandard(standards[i%2])
+		err = a.LoadDiskWithOptions(disk, DiskOptions{Drive: i % 2, WriteProtect: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.SendKey(KEY_A, i%2 == 1)
+		time.Sleep(time.Millisecond)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package izatom
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Speed control of the emulation. The methods on Atom can be called from
+any goroutine while Run is executing.
+
+The emulation is paced to the speed factor of the 1 MHz clock. On warp
+it runs as fast as possible. With auto warp it also runs unlimited while
+the disk is in use.
+*/
+
+const (
+	maxWaitDuration = 100 * time.Millisecond
+	cpuSpinLoops    = 100
+	cycleDurationNs = 1000 // 1 MHz
+	pausePollPeriod = 10 * time.Millisecond
+)
+
+type speedControl struct {
+	paused   atomic.Bool
+	warp     atomic.Bool
+	autoWarp atomic.Bool
+	speed    atomic.Uint64 // Bits of the float64 factor
+
+	// Used only from the emulation goroutine
+	needRebase      bool
+	referenceTime   time.Time
+	referenceCycles uint64
+	referenceSpeed  float64
+}
+
+func newSpeedControl() *speedControl {
+	var c speedControl
+	c.speed.Store(math.Float64bits(1))
+	c.needRebase = true
+	return &c
+}
+
+func (c *speedControl) getSpeed() float64 {
+	return math.Float64frombits(c.speed.Load())
+}
+
+func (c *speedControl) throttle(cycles uint64, diskBusy bool) {
+	if c.warp.Load() || (diskBusy && c.autoWarp.Load()) {
+		c.needRebase = true
+		return
+	}
+
+	speed := c.getSpeed()
+	if c.needRebase || speed != c.referenceSpeed {
+		c.referenceTime = time.Now()
+		c.referenceCycles = cycles
+		c.referenceSpeed = speed
+		c.needRebase = false
+		return
+	}
+
+	clockDuration := time.Since(c.referenceTime)
+	simulatedDuration := time.Duration(float64(cycles-c.referenceCycles) * cycleDurationNs / speed)
+	waitDuration := simulatedDuration - clockDuration
+	if waitDuration > maxWaitDuration || -waitDuration > maxWaitDuration {
+		// We have to wait too long or are too much behind. Let's fast forward
+		c.referenceTime = c.referenceTime.Add(-waitDuration)
+		waitDuration = 0
+	}
+	if waitDuration > 0 {
+		time.Sleep(waitDuration)
+	}
+}
+
+// Pause stops the emulation until Resume is called
+func (a *Atom) Pause() {
+	a.control.paused.Store(true)
+}
+
+// Resume continues the emulation after a Pause
+func (a *Atom) Resume() {
+	a.control.paused.Store(false)
+}
+
+// IsPaused returns true if the emulation is paused
+func (a *Atom) IsPaused() bool {
+	return a.control.paused.Load()
+}
+
+// SetSpeed sets the speed as a factor of the original, 1 is 1 MHz
+func (a *Atom) SetSpeed(factor float64) {
+	if factor > 0 {
+		a.control.speed.Store(math.Float64bits(factor))
+	}
+}
+
+// Speed returns the speed factor
+func (a *Atom) Speed() float64 {
+	return a.control.getSpeed()
+}
+
+// SetWarp runs the emulation as fast as possible, ignoring the speed factor
+func (a *Atom) SetWarp(warp bool) {
+	a.control.warp.Store(warp)
+}
+
+// IsWarp returns true if warp is enabled
+func (a *Atom) IsWarp() bool {
+	return a.control.warp.Load()
+}
+
+// SetAutoWarp enables the warp while the disk is busy
+func (a *Atom) SetAutoWarp(autoWarp bool) {
+	a.control.autoWarp.Store(autoWarp)
+}
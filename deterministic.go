@@ -0,0 +1,157 @@
+package izatom
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+Deterministic execution, for reproducible tests. RunCycles and RunFrames
+execute the emulation on the calling goroutine without pacing, ignoring
+the pause and the keys and joystick buttons sent with SendKey and
+SendJoystick. The inputs are scheduled at cycle counts with
+ScheduleInput and applied before the first instruction that starts on
+or after that cycle. With the same configuration, disks and inputs, the
+same calls give the same RAM, frames and audio.
+
+The frame listener is called as with Run. The configuration changes
+requested from other goroutines while executing are applied on the
+frame boundaries, to be deterministic they have to be requested before.
+*/
+
+// InputKind is the type of a scheduled input
+type InputKind int
+
+const (
+	// InputKey presses or releases Code, a KEY_* constant
+	InputKey InputKind = iota
+	// InputJoystick presses or releases Code, a JOYSTICK_* button
+	InputJoystick
+)
+
+// Input is an event applied when the CPU reaches a cycle count
+type Input struct {
+	Cycle    uint64
+	Kind     InputKind
+	Code     int
+	Released bool
+}
+
+/*
+ScheduleInput adds an input to be applied at its cycle. The inputs for
+the same cycle are applied in the order they were scheduled. An input
+for a cycle already executed is applied before the next instruction.
+*/
+func (a *Atom) ScheduleInput(input Input) error {
+	switch input.Kind {
+	case InputKey:
+		if input.Code < 0 || input.Code >= KEY_NONE {
+			return fmt.Errorf("invalid key %v", input.Code)
+		}
+	case InputJoystick:
+		if input.Code < 0 || input.Code >= JOYSTICK_SIZE {
+			return fmt.Errorf("invalid joystick button %v", input.Code)
+		}
+	default:
+		return fmt.Errorf("invalid input kind %v", input.Kind)
+	}
+
+	a.configure(func() {
+		a.schedule(input.Cycle, func() {
+			if input.Kind == InputKey {
+				a.pressKey(input.Code, input.Released)
+			} else {
+				a.pressButton(input.Code, input.Released)
+			}
+		})
+	})
+	return nil
+}
+
+type scheduledInput struct {
+	cycle uint64
+	apply func()
+}
+
+// Adds an action to the inputs, from the emulation goroutine
+func (a *Atom) schedule(cycle uint64, apply func()) {
+	i := sort.Search(len(a.inputs), func(i int) bool {
+		return a.inputs[i].cycle > cycle
+	})
+	a.inputs = append(a.inputs, scheduledInput{})
+	copy(a.inputs[i+1:], a.inputs[i:])
+	a.inputs[i] = scheduledInput{cycle, apply}
+}
+
+// Applies the inputs scheduled up to the cycle
+func (a *Atom) applyInputs(cycle uint64) {
+	for len(a.inputs) > 0 && a.inputs[0].cycle <= cycle {
+		apply := a.inputs[0].apply
+		a.inputs = a.inputs[1:]
+		apply()
+	}
+}
+
+// The inputs, live or scheduled, are applied and recorded with these
+func (a *Atom) pressKey(key int, released bool) {
+	a.keyboard.isPressed[key] = !released
+	a.movie.recordInput(movieKey, a.cpu.GetCycles(), key, released)
+}
+
+func (a *Atom) pressButton(button int, released bool) {
+	a.joystick.isPressed[button] = !released
+	a.movie.recordInput(movieJoystick, a.cpu.GetCycles(), button, released)
+}
+
+/*
+Cycles returns the number of CPU cycles executed since the Atom was
+created. To be called when the emulation is not executing, or from the
+frame listener.
+*/
+func (a *Atom) Cycles() uint64 {
+	return a.cpu.GetCycles()
+}
+
+/*
+RunCycles executes instructions until at least n more cycles have been
+executed. The last instruction may end a few cycles past the target. The
+disk changes are saved before returning. An error is returned if the
+emulation is already running, can't continue or the disk can't be saved.
+*/
+func (a *Atom) RunCycles(n uint64) (err error) {
+	err = a.start()
+	if err != nil {
+		return err
+	}
+	defer a.stop(&err)
+
+	end := a.cpu.GetCycles() + n
+	for a.cpu.GetCycles() < end {
+		if a.step() && a.Halted() != nil {
+			return
+		}
+	}
+	return nil
+}
+
+/*
+RunFrames executes instructions until n more frames have been completed
+and sent to the frame listener. It returns as RunCycles.
+*/
+func (a *Atom) RunFrames(n int) (err error) {
+	err = a.start()
+	if err != nil {
+		return err
+	}
+	defer a.stop(&err)
+
+	for n > 0 {
+		if a.step() {
+			if a.Halted() != nil {
+				return
+			}
+			n--
+		}
+	}
+	return nil
+}
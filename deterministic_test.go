@@ -0,0 +1,78 @@
+package izatom
+
+import (
+	"bytes"
+	"hash/crc32"
+	"image"
+	"testing"
+)
+
+// The state after a deterministic execution
+type runResult struct {
+	ram    []uint8
+	cycles uint64
+	frame  *image.RGBA
+	audio  uint32 // CRC32 of the samples
+}
+
+func runScheduled(t *testing.T) runResult {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetJoystickMode(JoystickVIA)
+	var r runResult
+	audio := crc32.NewIEEE()
+	a.SetFrameListener(func(frame *image.RGBA, samples []int16) {
+		r.frame = image.NewRGBA(frame.Bounds())
+		copy(r.frame.Pix, frame.Pix)
+		for _, s := range samples {
+			audio.Write([]uint8{uint8(s), uint8(s >> 8)})
+		}
+	})
+
+	runFrames(t, a, bootFrames)
+	typeLines(t, a, "10 FOR I=0 TO 127;?(#3000+I)=?#B800;NEXT I", "20 END")
+
+	// Fire toggles while the program samples the joystick
+	start := a.Cycles() + 4*keyInterval
+	for i := uint64(0); i < 60; i++ {
+		err = a.ScheduleInput(Input{Cycle: start + i*5_003, Kind: InputJoystick,
+			Code: JOYSTICK_FIRE, Released: i%2 == 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	typeLines(t, a, "RUN")
+
+	r.ram = append([]uint8(nil), a.ram[:]...)
+	r.cycles = a.Cycles()
+	r.audio = audio.Sum32()
+	return r
+}
+
+func TestDeterministicRuns(t *testing.T) {
+	first := runScheduled(t)
+	second := runScheduled(t)
+	if first.cycles != second.cycles {
+		t.Errorf("the runs end on cycles %v and %v", first.cycles, second.cycles)
+	}
+	if !bytes.Equal(first.ram, second.ram) {
+		t.Error("the RAM differs")
+	}
+	if first.frame == nil || !bytes.Equal(first.frame.Pix, second.frame.Pix) {
+		t.Error("the last frame differs")
+	}
+	if first.audio != second.audio {
+		t.Error("the audio differs")
+	}
+
+	samples := map[uint8]bool{}
+	for _, v := range first.ram[0x3000:0x3080] {
+		samples[v] = true
+	}
+	if len(samples) < 2 {
+		t.Errorf("the program didn't see the fire button, it read %v", samples)
+	}
+}
@@ -0,0 +1,146 @@
+package izatom
+
+import (
+	"fmt"
+)
+
+/*
+Device is a peripheral on the expansion bus of the Atom, to be
+implemented outside of this package. It is attached with AttachDevice
+and its methods are called from the emulation goroutine.
+*/
+type Device interface {
+	// Name identifies the device on the memory map and on the saved states
+	Name() string
+	// Read is a read by the CPU. The offset is relative to the start of the area and masked.
+	Read(offset uint16) uint8
+	// Peek returns the value Read would return, without side effects
+	Peek(offset uint16) uint8
+	// Write is a write by the CPU. The offset is relative to the start of the area and masked.
+	Write(offset uint16, value uint8)
+	// Tick is called after every CPU instruction with the cycles since power on
+	Tick(cycle uint64)
+	// Reset is called when BREAK is pressed
+	Reset()
+	// IRQ returns true while the device asserts the IRQ line, it is shared with the other devices
+	IRQ() bool
+	// NMI returns true while the device asserts the NMI line, the NMI is triggered on the transition
+	NMI() bool
+	// SaveState returns the internal state of the device
+	SaveState() ([]byte, error)
+	// LoadState restores a state returned by SaveState
+	LoadState(state []byte) error
+}
+
+/*
+DeviceArea is the address range decoded by a device, made of whole
+pages of 256 bytes. The mask has the bits of the offset decoded, the
+rest of the area are mirrors. The zero value is for devices without
+registers.
+*/
+type DeviceArea struct {
+	Start uint16
+	End   uint16 // Inclusive
+	Mask  uint16
+}
+
+type attachedDevice struct {
+	device Device
+	nmi    bool
+	irq    *irqSource
+}
+
+/*
+AttachDevice connects a device to the Atom. The area replaces what was
+mapped on those addresses, see MemoryMap.
+*/
+func (a *Atom) AttachDevice(device Device, area DeviceArea) error {
+	var mapped *memoryArea
+	if area != (DeviceArea{}) {
+		mapped = &memoryArea{
+			name:  device.Name(),
+			start: area.Start,
+			end:   area.End,
+			mask:  area.Mask,
+			read:  device.Read,
+			peek:  device.Peek,
+			write: device.Write,
+		}
+		err := mapped.check()
+		if err != nil {
+			return err
+		}
+	}
+
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	for _, name := range a.deviceNames {
+		if name == device.Name() {
+			return fmt.Errorf("there is already a device named %v", name)
+		}
+	}
+	a.deviceNames = append(a.deviceNames, device.Name())
+
+	a.configureLocked(func() {
+		if mapped != nil {
+			a.memory.add(mapped) // Already checked
+		}
+		a.devices = append(a.devices, &attachedDevice{
+			device: device,
+			irq:    a.irq.addSource(device.Name()),
+		})
+	})
+	return nil
+}
+
+func (a *Atom) tickDevices(cycle uint64) {
+	for _, d := range a.devices {
+		d.device.Tick(cycle)
+		nmi := d.device.NMI()
+		if nmi && !d.nmi {
+			a.cpu.RaiseNMI()
+		}
+		d.nmi = nmi
+		d.irq.set(d.device.IRQ())
+	}
+}
+
+func (a *Atom) resetDevices() {
+	for _, d := range a.devices {
+		d.device.Reset()
+	}
+}
+
+/*
+SaveDeviceStates returns the states of the attached devices by name. It
+is to be called when Run is not executing.
+*/
+func (a *Atom) SaveDeviceStates() (map[string][]byte, error) {
+	states := make(map[string][]byte)
+	for _, d := range a.devices {
+		state, err := d.device.SaveState()
+		if err != nil {
+			return nil, fmt.Errorf("error saving the state of %v: %w", d.device.Name(), err)
+		}
+		states[d.device.Name()] = state
+	}
+	return states, nil
+}
+
+/*
+LoadDeviceStates restores the states of the attached devices returned by
+SaveDeviceStates. It is to be called when Run is not executing.
+*/
+func (a *Atom) LoadDeviceStates(states map[string][]byte) error {
+	for _, d := range a.devices {
+		state, ok := states[d.device.Name()]
+		if !ok {
+			continue // Keep the current state
+		}
+		err := d.device.LoadState(state)
+		if err != nil {
+			return fmt.Errorf("error loading the state of %v: %w", d.device.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package izatom
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The disks as seen by the 8271: a track is a sequence of sector IDs, each
+followed by its data field, at some position from the index pulse. The
+sector images are presented in the layout of a DOS format. The flux
+images, HFE and SCP, are decoded from FM and keep what is on the real
+disk: deleted data marks, odd sector IDs, CRC errors and weak bits.
+*/
+
+const (
+	diskTracks          = 40
+	diskSectorsPerTrack = 10
+	diskSectorSize      = 256
+	diskTrackBytes      = fdcRevolutionCycles / fdcByteCycles
+
+	// Bytes on the track with the usual format
+	layoutGap1       = 16  // Before the first sector
+	layoutSectorSlot = 310 // Sync, ID, gap 2, sync, data mark, data, CRC and gap 3
+	layoutIDToData   = 25  // ID with CRC, gap 2, sync and data mark
+	layoutIDSize     = 7   // Mark, C, H, R, N and CRC
+	layoutCRCSize    = 2
+	layoutSync       = 6
+)
+
+type trackSector struct {
+	id      [4]uint8 // C, H, R and N
+	idCRCOK bool
+	idPos   int // Position in bytes from the index of the ID mark
+
+	data      []uint8 // nil if there is no data field
+	dataCRCOK bool
+	dataPos   int  // Position of the data mark
+	deleted   bool // Deleted data mark
+	weak      []bool
+}
+
+type diskTrack struct {
+	sectors []trackSector // In the order they pass under the head
+}
+
+type floppyDisk interface {
+	// track returns the sectors on a physical track, nil if it is not formatted
+	track(physical uint8) *diskTrack
+	isWritable() bool
+	// formatSector writes a sector with the ID given, false if the image can't store it
+	formatSector(physical uint8, id [4]uint8) bool
+	// image returns the contents for the file
+	image() []uint8
+}
+
+// Position of the ID of a sector, with the usual format
+func sectorIDPosition(sector int) int {
+	return layoutGap1 + sector*layoutSectorSlot
+}
+
+// newFloppyDisk identifies the format of the image by the signature or the extension
+func newFloppyDisk(name string, data []uint8) (floppyDisk, error) {
+	switch {
+	case bytes.HasPrefix(data, []uint8(hfeSignature)):
+		return loadHFE(data)
+	case bytes.HasPrefix(data, []uint8(scpSignature)):
+		return loadSCP(data)
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".hfe" || ext == ".scp" {
+		return nil, fmt.Errorf("%v is not a valid %v image", name, ext[1:])
+	}
+	return &sectorDisk{data: data}, nil
+}
+
+/*
+Sector images, .40t or .dsk, have the 256 bytes sectors one after
+another, 10 sectors per track.
+*/
+type sectorDisk struct {
+	data []uint8
+}
+
+func (s *sectorDisk) track(physical uint8) *diskTrack {
+	var t diskTrack
+	for sector := 0; sector < diskSectorsPerTrack; sector++ {
+		offset := (int(physical)*diskSectorsPerTrack + sector) * diskSectorSize
+		if offset+diskSectorSize > len(s.data) {
+			break
+		}
+		idPos := sectorIDPosition(sector)
+		t.sectors = append(t.sectors, trackSector{
+			id:        [4]uint8{physical, 0, uint8(sector), 1},
+			idCRCOK:   true,
+			idPos:     idPos,
+			data:      s.data[offset : offset+diskSectorSize], // Writes go to the image
+			dataCRCOK: true,
+			dataPos:   idPos + layoutIDToData - 1,
+		})
+	}
+	if t.sectors == nil {
+		return nil
+	}
+	return &t
+}
+
+func (s *sectorDisk) isWritable() bool {
+	return true
+}
+
+func (s *sectorDisk) formatSector(physical uint8, id [4]uint8) bool {
+	end := (int(physical) + 1) * diskSectorsPerTrack * diskSectorSize
+	if end > len(s.data) {
+		// The image grows to have the track
+		s.data = append(s.data, make([]uint8, end-len(s.data))...)
+	}
+
+	sector := int(id[2])
+	if sector >= diskSectorsPerTrack || id[3] != 1 {
+		return false
+	}
+	offset := (int(physical)*diskSectorsPerTrack + sector) * diskSectorSize
+	for i := 0; i < diskSectorSize; i++ {
+		s.data[offset+i] = 0xe5
+	}
+	return true
+}
+
+func (s *sectorDisk) image() []uint8 {
+	return s.data
+}
+
+/*
+Flux images are decoded when loaded. They are read only, writing would
+need an encoder back to the flux format.
+*/
+type fluxDisk struct {
+	tracks []*diskTrack
+	data   []uint8
+}
+
+func (f *fluxDisk) track(physical uint8) *diskTrack {
+	if int(physical) >= len(f.tracks) {
+		return nil
+	}
+	return f.tracks[physical]
+}
+
+func (f *fluxDisk) isWritable() bool {
+	return false
+}
+
+func (f *fluxDisk) formatSector(physical uint8, id [4]uint8) bool {
+	return false
+}
+
+func (f *fluxDisk) image() []uint8 {
+	return f.data
+}
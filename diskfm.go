@@ -0,0 +1,210 @@
+package izatom
+
+/*
+FM decoding of the flux transitions. Each bit cell of 8us has a clock
+half and a data half, with a flux transition for a one. The address
+marks have missing clock bits to be found on the stream:
+	ID mark:           data 0xfe, clock 0xc7
+	Data mark:         data 0xfb or 0xfa, clock 0xc7
+	Deleted data mark: data 0xf8 or 0xf9, clock 0xc7
+The mark, the bytes and the CRC-CCITT of the field follow.
+*/
+
+const (
+	fmHalfCellMicros = 4.0
+	fmMarkClock      = 0xc7
+	fmIDMark         = 0xfe
+	fmMaxIDToData    = 50 // Bytes from the ID mark to its data mark
+)
+
+// fluxRevolution has the intervals in microseconds between flux transitions from the index
+type fluxRevolution []float64
+
+// Returns the half cells of a revolution, true for a flux transition
+func fmCells(revolution fluxRevolution) []bool {
+	var cells []bool
+	period := fmHalfCellMicros
+	for _, interval := range revolution {
+		n := int(interval/period + 0.5)
+		if n < 1 {
+			n = 1
+		}
+		for i := 1; i < n; i++ {
+			cells = append(cells, false)
+		}
+		cells = append(cells, true)
+
+		// Follow slowly the speed variations of the drive that captured the image
+		period += (interval/float64(n) - period) / 16
+		if period < fmHalfCellMicros*0.9 {
+			period = fmHalfCellMicros * 0.9
+		} else if period > fmHalfCellMicros*1.1 {
+			period = fmHalfCellMicros * 1.1
+		}
+	}
+	return cells
+}
+
+// Returns the 16 half cells of a byte with its clock
+func fmInterleave(clock uint8, data uint8) uint16 {
+	var v uint16
+	for i := 7; i >= 0; i-- {
+		v = v<<2 | uint16(clock>>i&1)<<1 | uint16(data>>i&1)
+	}
+	return v
+}
+
+// Returns the data of the bytes starting at a cell, false if the revolution ends before
+func fmBytes(cells []bool, at int, count int) ([]uint8, bool) {
+	if at+count*16 > len(cells) {
+		return nil, false
+	}
+	data := make([]uint8, count)
+	for i := range data {
+		for bit := 0; bit < 8; bit++ {
+			data[i] <<= 1
+			if cells[at+i*16+bit*2+1] {
+				data[i] |= 1
+			}
+		}
+	}
+	return data, true
+}
+
+func crcCCITT(crc uint16, data []uint8) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Checks the CRC of a field, the mark, the bytes and two CRC bytes
+func fmCRCOK(mark uint8, field []uint8) bool {
+	size := len(field) - 2
+	crc := crcCCITT(crcCCITT(0xffff, []uint8{mark}), field[:size])
+	return crc == uint16(field[size])<<8|uint16(field[size+1])
+}
+
+// decodeFM finds the sectors on the half cells of a revolution, nil if there are none
+func decodeFM(cells []bool) *diskTrack {
+	idMark := fmInterleave(fmMarkClock, fmIDMark)
+	dataMarks := map[uint16]uint8{}
+	for mark := uint8(0xf8); mark <= 0xfb; mark++ {
+		dataMarks[fmInterleave(fmMarkClock, mark)] = mark
+	}
+
+	// The positions are scaled to the emulated revolution
+	scale := float64(diskTrackBytes) / (float64(len(cells)) / 16)
+	var t diskTrack
+	last := -1 // The last ID, waiting for its data
+	var reg uint16
+	for i := 0; i < len(cells); i++ {
+		reg <<= 1
+		if cells[i] {
+			reg |= 1
+		}
+		if i < 15 {
+			continue
+		}
+		pos := int(float64(i-15) / 16 * scale)
+
+		if reg == idMark {
+			field, ok := fmBytes(cells, i+1, 6)
+			if !ok {
+				break
+			}
+			var s trackSector
+			copy(s.id[:], field)
+			s.idCRCOK = fmCRCOK(fmIDMark, field)
+			s.idPos = pos
+			t.sectors = append(t.sectors, s)
+			last = len(t.sectors) - 1
+			i += len(field) * 16
+			reg = 0
+		} else if mark, isData := dataMarks[reg]; isData {
+			if last < 0 || pos-t.sectors[last].idPos > fmMaxIDToData {
+				continue // Data without ID
+			}
+			s := &t.sectors[last]
+			size := 128 << (s.id[3] & 0x07)
+			field, ok := fmBytes(cells, i+1, size+2)
+			if !ok {
+				break
+			}
+			s.data = field[:size]
+			s.dataCRCOK = fmCRCOK(mark, field)
+			s.dataPos = pos
+			s.deleted = mark == 0xf8 || mark == 0xf9
+			last = -1
+			i += len(field) * 16
+			reg = 0
+		}
+	}
+
+	if t.sectors == nil {
+		return nil
+	}
+	return &t
+}
+
+/*
+decodeRevolutions decodes the first revolution of a track. The bytes of
+the data fields that read different on the other revolutions are weak.
+*/
+func decodeRevolutions(revolutions []fluxRevolution) *diskTrack {
+	if len(revolutions) == 0 {
+		return nil
+	}
+	t := decodeFM(fmCells(revolutions[0]))
+	if t == nil {
+		return nil
+	}
+
+	for _, revolution := range revolutions[1:] {
+		other := decodeFM(fmCells(revolution))
+		if other == nil {
+			continue
+		}
+		for i := range t.sectors {
+			s := &t.sectors[i]
+			o := other.find(s.id, s.idPos)
+			if s.data == nil || o == nil || len(o.data) != len(s.data) {
+				continue
+			}
+			for j := range s.data {
+				if s.data[j] != o.data[j] {
+					if s.weak == nil {
+						s.weak = make([]bool, len(s.data))
+					}
+					s.weak[j] = true
+				}
+			}
+		}
+	}
+	return t
+}
+
+// Returns the sector with the ID nearest to a position
+func (t *diskTrack) find(id [4]uint8, pos int) *trackSector {
+	var found *trackSector
+	distance := fmMaxIDToData
+	for i := range t.sectors {
+		s := &t.sectors[i]
+		d := s.idPos - pos
+		if d < 0 {
+			d = -d
+		}
+		if s.id == id && d <= distance {
+			found = s
+			distance = d
+		}
+	}
+	return found
+}
@@ -0,0 +1,164 @@
+package izatom
+
+import (
+	"bytes"
+	"testing"
+)
+
+type fmTestSector struct {
+	id     [4]uint8
+	data   []uint8
+	mark   uint8 // 0 for the data mark 0xfb
+	badCRC bool  // On the data field
+}
+
+// Three sectors of 256 bytes, the second deleted and the third with a CRC error
+func fmTestSectors() []fmTestSector {
+	sectors := []fmTestSector{
+		{id: [4]uint8{0, 0, 0, 1}},
+		{id: [4]uint8{0, 0, 1, 1}, mark: 0xf8},
+		{id: [4]uint8{0, 0, 2, 1}, badCRC: true},
+	}
+	for i := range sectors {
+		sectors[i].data = make([]uint8, 256)
+		for j := range sectors[i].data {
+			sectors[i].data[j] = uint8(j*7 + i)
+		}
+	}
+	return sectors
+}
+
+// Returns the half cells of a revolution with the sectors, with the usual gaps
+func fmEncodeTrack(sectors []fmTestSector) []bool {
+	var cells []bool
+	add := func(clock uint8, data uint8) {
+		v := fmInterleave(clock, data)
+		for i := 15; i >= 0; i-- {
+			cells = append(cells, v>>i&1 != 0)
+		}
+	}
+	fill := func(count int, data uint8) {
+		for i := 0; i < count; i++ {
+			add(0xff, data)
+		}
+	}
+	field := func(mark uint8, content []uint8, badCRC bool) {
+		add(fmMarkClock, mark)
+		for _, b := range content {
+			add(0xff, b)
+		}
+		crc := crcCCITT(crcCCITT(0xffff, []uint8{mark}), content)
+		if badCRC {
+			crc ^= 0x0001
+		}
+		add(0xff, uint8(crc>>8))
+		add(0xff, uint8(crc))
+	}
+
+	fill(16, 0xff)
+	for _, s := range sectors {
+		mark := s.mark
+		if mark == 0 {
+			mark = 0xfb
+		}
+		fill(6, 0x00)
+		field(fmIDMark, s.id[:], false)
+		fill(11, 0xff)
+		fill(6, 0x00)
+		field(mark, s.data, s.badCRC)
+		fill(27, 0xff)
+	}
+	fill(diskTrackBytes-len(cells)/16, 0xff) // To a whole revolution
+	return cells
+}
+
+// Returns the intervals between the flux transitions of the half cells
+func fmFlux(cells []bool) fluxRevolution {
+	var revolution fluxRevolution
+	interval := 0.0
+	for _, cell := range cells {
+		interval += fmHalfCellMicros
+		if cell {
+			revolution = append(revolution, interval)
+			interval = 0
+		}
+	}
+	return revolution
+}
+
+func checkFMTrack(t *testing.T, track *diskTrack, sectors []fmTestSector) {
+	t.Helper()
+	if track == nil {
+		t.Fatal("the track is not formatted")
+	}
+	if len(track.sectors) != len(sectors) {
+		t.Fatalf("%v sectors decoded, not %v", len(track.sectors), len(sectors))
+	}
+	for i, s := range sectors {
+		d := track.sectors[i]
+		if d.id != s.id || !d.idCRCOK {
+			t.Errorf("sector %v: the ID is %v, CRC ok %v", i, d.id, d.idCRCOK)
+		}
+		if !bytes.Equal(d.data, s.data) {
+			t.Errorf("sector %v: the data is not the encoded", i)
+		}
+		if d.dataCRCOK == s.badCRC {
+			t.Errorf("sector %v: the data CRC ok is %v", i, d.dataCRCOK)
+		}
+		if d.deleted != (s.mark == 0xf8) {
+			t.Errorf("sector %v: deleted is %v", i, d.deleted)
+		}
+		if d.dataPos <= d.idPos || d.dataPos-d.idPos > fmMaxIDToData {
+			t.Errorf("sector %v: the data is at %v and the ID at %v", i, d.dataPos, d.idPos)
+		}
+	}
+}
+
+func TestDecodeFM(t *testing.T) {
+	sectors := fmTestSectors()
+	track := decodeRevolutions([]fluxRevolution{fmFlux(fmEncodeTrack(sectors))})
+	checkFMTrack(t, track, sectors)
+}
+
+func TestDecodeFMSpeedVariation(t *testing.T) {
+	sectors := fmTestSectors()
+	revolution := fmFlux(fmEncodeTrack(sectors))
+	for i := range revolution {
+		revolution[i] *= 1.05 // A slower drive
+	}
+	track := decodeRevolutions([]fluxRevolution{revolution})
+	checkFMTrack(t, track, sectors)
+}
+
+func TestDecodeFMWeakBytes(t *testing.T) {
+	sectors := fmTestSectors()
+	first := fmFlux(fmEncodeTrack(sectors))
+	sectors[0].data[10] ^= 0xff
+	second := fmFlux(fmEncodeTrack(sectors))
+	sectors[0].data[10] ^= 0xff
+
+	track := decodeRevolutions([]fluxRevolution{first, second})
+	checkFMTrack(t, track, sectors)
+	weak := track.sectors[0].weak
+	if weak == nil {
+		t.Fatal("the first sector has no weak bytes")
+	}
+	for i, w := range weak {
+		if w != (i == 10) {
+			t.Errorf("byte %v is weak %v", i, w)
+		}
+	}
+	if track.sectors[1].weak != nil {
+		t.Error("the second sector has weak bytes")
+	}
+}
+
+func TestDecodeFMUnformatted(t *testing.T) {
+	cells := make([]bool, 16*1000)
+	for i := range cells {
+		cells[i] = i%2 == 0 // Clocks without data
+	}
+	if track := decodeRevolutions([]fluxRevolution{fmFlux(cells)}); track != nil {
+		t.Errorf("%v sectors found on an unformatted track", len(track.sectors))
+	}
+}
@@ -0,0 +1,74 @@
+package izatom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+HFE images of the HxC floppy emulator, version 1. The header has:
+	0x000 "HXCPICFE"
+	0x008 Format revision, 0
+	0x009 Number of tracks
+	0x00a Number of sides
+	0x00b Track encoding
+	0x00c Bit rate in kbit/s, 16 bits little endian
+	0x00e Rotation speed in rpm
+	0x010 Interface mode
+	0x012 Offset of the track list in 512 bytes blocks
+The track list has for each track the offset in blocks and the length in
+bytes of the track data. The track data is in blocks of 512 bytes, the
+first 256 bytes for side 0 and the next 256 for side 1. The bits are sent
+least significant first, a one is a flux transition, at twice the bit rate.
+*/
+
+const (
+	hfeSignature = "HXCPICFE"
+	hfeBlockSize = 512
+)
+
+func loadHFE(data []uint8) (floppyDisk, error) {
+	if len(data) < hfeBlockSize {
+		return nil, fmt.Errorf("the HFE header is incomplete")
+	}
+	revision := data[0x08]
+	tracks := int(data[0x09])
+	bitRate := int(binary.LittleEndian.Uint16(data[0x0c:]))
+	listOffset := int(binary.LittleEndian.Uint16(data[0x12:])) * hfeBlockSize
+	if revision != 0 {
+		return nil, fmt.Errorf("HFE format revision %v not supported", revision)
+	}
+	if bitRate == 0 {
+		return nil, fmt.Errorf("the HFE image has no bit rate")
+	}
+	if listOffset+tracks*4 > len(data) {
+		return nil, fmt.Errorf("the HFE track list is outside of the file")
+	}
+
+	cellMicros := 500 / float64(bitRate)
+	disk := fluxDisk{data: data}
+	for track := 0; track < tracks; track++ {
+		entry := data[listOffset+track*4:]
+		offset := int(binary.LittleEndian.Uint16(entry)) * hfeBlockSize
+		length := int(binary.LittleEndian.Uint16(entry[2:]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("the HFE track %v is outside of the file", track)
+		}
+
+		// Only side 0
+		var revolution fluxRevolution
+		interval := 0.0
+		for i := 0; i < length/2; i++ {
+			b := data[offset+i/256*hfeBlockSize+i%256]
+			for bit := 0; bit < 8; bit++ {
+				interval += cellMicros
+				if b&(1<<bit) != 0 {
+					revolution = append(revolution, interval)
+					interval = 0
+				}
+			}
+		}
+		disk.tracks = append(disk.tracks, decodeRevolutions([]fluxRevolution{revolution}))
+	}
+	return &disk, nil
+}
@@ -0,0 +1,83 @@
+package izatom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+/*
+Returns an HFE image with the half cells on track 0 side 0. At 250 kbit/s
+each HFE bit is 2us, two of them for an FM half cell.
+*/
+func makeHFE(cells []bool) []uint8 {
+	sideBytes := (len(cells)*2 + 7) / 8
+	blocks := (sideBytes + 255) / 256
+	data := make([]uint8, (2+blocks)*hfeBlockSize)
+	for i := range data {
+		data[i] = 0xff
+	}
+
+	copy(data, hfeSignature)
+	data[0x08] = 0 // Revision
+	data[0x09] = 1 // Tracks
+	data[0x0a] = 1 // Sides
+	data[0x0b] = 2 // FM
+	binary.LittleEndian.PutUint16(data[0x0c:], 250)
+	binary.LittleEndian.PutUint16(data[0x0e:], 300)
+	data[0x10] = 0 // Interface
+	binary.LittleEndian.PutUint16(data[0x12:], 1)
+
+	list := data[hfeBlockSize:]
+	binary.LittleEndian.PutUint16(list, 2)
+	binary.LittleEndian.PutUint16(list[2:], uint16(sideBytes*2))
+
+	track := data[2*hfeBlockSize:]
+	for i := 0; i < sideBytes; i++ {
+		track[i/256*hfeBlockSize+i%256] = 0
+	}
+	for i, cell := range cells {
+		if cell {
+			bit := i * 2
+			track[bit/8/256*hfeBlockSize+bit/8%256] |= 1 << (bit % 8)
+		}
+	}
+	return data
+}
+
+func TestLoadHFE(t *testing.T) {
+	sectors := fmTestSectors()
+	disk, err := newFloppyDisk("test.hfe", makeHFE(fmEncodeTrack(sectors)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.isWritable() {
+		t.Error("the HFE image is writable")
+	}
+	checkFMTrack(t, disk.track(0), sectors)
+	if disk.track(1) != nil {
+		t.Error("there is a track 1")
+	}
+}
+
+func TestLoadHFEErrors(t *testing.T) {
+	valid := func() []uint8 {
+		return makeHFE(fmEncodeTrack(fmTestSectors()))
+	}
+	images := []struct {
+		name string
+		data []uint8
+	}{
+		{"truncated header", valid()[:100]},
+		{"revision 1", func() []uint8 { d := valid(); d[0x08] = 1; return d }()},
+		{"no bit rate", func() []uint8 { d := valid(); d[0x0c], d[0x0d] = 0, 0; return d }()},
+		{"track list outside", func() []uint8 { d := valid(); d[0x12] = 0xff; return d }()},
+		{"track outside", func() []uint8 { d := valid(); d[hfeBlockSize] = 0xff; return d }()},
+		{"no signature", valid()[8:]},
+	}
+	for _, image := range images {
+		_, err := newFloppyDisk("test.hfe", image.data)
+		if err == nil {
+			t.Errorf("%v: no error", image.name)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package izatom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+)
+
+/*
+Copy-on-write overlays. With the overlay enabled the changes to a disk
+are saved on a sidecar file, the path of the image plus ".overlay", and
+the image is never written. The sidecar is applied when the disk is
+loaded again with the overlay, until it is committed to the image or
+discarded.
+
+The sidecar has the signature "IZOVRLY1", the CRC32 of the image it
+applies to, the size of the changed image and the changed blocks, each
+with its offset, its length and the bytes. The numbers are 32 bits big
+endian.
+*/
+
+const (
+	overlaySignature = "IZOVRLY1"
+	overlaySuffix    = ".overlay"
+	overlayBlockSize = diskSectorSize
+)
+
+// DiskOptions are the options of LoadDiskWithOptions
+type DiskOptions struct {
+	Drive        int // 0 or 1
+	WriteProtect bool
+	Overlay      bool // The changes go to a sidecar file instead of the image
+}
+
+/*
+LoadDiskWithOptions inserts a disk image on a drive. The changes of the
+disk replaced are saved first, an error saving them is returned and the
+disk is not replaced. Not to be called from the frame listener.
+*/
+func (a *Atom) LoadDiskWithOptions(path string, options DiskOptions) error {
+	if options.Drive < 0 || options.Drive >= len(a.fdc.drives) {
+		return fmt.Errorf("there is no drive %v", options.Drive)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var original []uint8
+	if options.Overlay {
+		original = data
+		data, err = readOverlay(path, original)
+		if err != nil {
+			return err
+		}
+	}
+
+	disk, err := newFloppyDisk(path, data)
+	if err != nil {
+		return err
+	}
+	return a.configureWait(func() error {
+		return a.insertDisk(path, options, disk, original)
+	})
+}
+
+/*
+Inserts and records the disk, from the emulation goroutine. If the
+changes of the disk replaced can't be saved, it stays on the drive.
+*/
+func (a *Atom) insertDisk(path string, options DiskOptions, disk floppyDisk, original []uint8) error {
+	err := a.fdc.loadDisk(options.Drive, path, disk)
+	if err != nil {
+		return err
+	}
+	d := &a.fdc.drives[options.Drive]
+	d.writeProtect = options.WriteProtect
+	d.overlay = options.Overlay
+	d.original = original
+	a.movie.recordDisk(a.cpu.GetCycles(), options.Drive, d)
+	return nil
+}
+
+// SetWriteProtect sets the write protect tab of the disk on a drive
+func (a *Atom) SetWriteProtect(drive int, protect bool) {
+	if drive < 0 || drive >= len(a.fdc.drives) {
+		return
+	}
+	a.configure(func() {
+		a.setWriteProtect(drive, protect)
+	})
+}
+
+func (a *Atom) setWriteProtect(drive int, protect bool) {
+	a.fdc.drives[drive].writeProtect = protect
+	a.movie.recordInput(movieProtect, a.cpu.GetCycles(), drive, protect)
+}
+
+/*
+CommitOverlay writes the disk of a drive loaded with an overlay to its
+image and removes the sidecar file.
+*/
+func (a *Atom) CommitOverlay(drive int) error {
+	if drive < 0 || drive >= len(a.fdc.drives) {
+		return fmt.Errorf("there is no drive %v", drive)
+	}
+	return a.configureWait(func() error {
+		return a.fdc.drives[drive].commitOverlay()
+	})
+}
+
+/*
+DiscardOverlay reverts the disk of a drive loaded with an overlay to its
+image and removes the sidecar file.
+*/
+func (a *Atom) DiscardOverlay(drive int) error {
+	if drive < 0 || drive >= len(a.fdc.drives) {
+		return fmt.Errorf("there is no drive %v", drive)
+	}
+	return a.configureWait(func() error {
+		d := &a.fdc.drives[drive]
+		err := d.discardOverlay()
+		if err == nil {
+			a.movie.recordDisk(a.cpu.GetCycles(), drive, d)
+		}
+		return err
+	})
+}
+
+func (d *floppyDrive) commitOverlay() error {
+	if d.disk == nil || !d.overlay {
+		return errors.New("the disk has no overlay")
+	}
+	image := d.disk.image()
+	err := os.WriteFile(d.path, image, 0644)
+	if err != nil {
+		return err
+	}
+	d.original = append([]uint8(nil), image...)
+	d.dirty = false
+	return removeOverlay(d.path)
+}
+
+func (d *floppyDrive) discardOverlay() error {
+	if d.disk == nil || !d.overlay {
+		return errors.New("the disk has no overlay")
+	}
+	disk, err := newFloppyDisk(d.path, append([]uint8(nil), d.original...))
+	if err != nil {
+		return err
+	}
+	d.disk = disk
+	d.dirty = false
+	return removeOverlay(d.path)
+}
+
+// Saves the changes on the sidecar file, or removes it if there are none
+func (d *floppyDrive) saveOverlay() error {
+	image := d.disk.image()
+	if bytes.Equal(image, d.original) {
+		return removeOverlay(d.path)
+	}
+	return os.WriteFile(d.path+overlaySuffix, makeOverlay(d.original, image), 0644)
+}
+
+func removeOverlay(path string) error {
+	err := os.Remove(path + overlaySuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Returns a copy of the image with the sidecar applied, if there is one
+func readOverlay(path string, original []uint8) ([]uint8, error) {
+	sidecar, err := os.ReadFile(path + overlaySuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return append([]uint8(nil), original...), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := applyOverlay(original, sidecar)
+	if err != nil {
+		return nil, fmt.Errorf("%v%v: %w", path, overlaySuffix, err)
+	}
+	return data, nil
+}
+
+// Returns the blocks of changed that differ from original
+func makeOverlay(original []uint8, changed []uint8) []uint8 {
+	var b bytes.Buffer
+	b.WriteString(overlaySignature)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(original))
+	binary.Write(&b, binary.BigEndian, uint32(len(changed)))
+
+	for offset := 0; offset < len(changed); offset += overlayBlockSize {
+		end := offset + overlayBlockSize
+		if end > len(changed) {
+			end = len(changed)
+		}
+		if end <= len(original) && bytes.Equal(changed[offset:end], original[offset:end]) {
+			continue
+		}
+		binary.Write(&b, binary.BigEndian, uint32(offset))
+		binary.Write(&b, binary.BigEndian, uint32(end-offset))
+		b.Write(changed[offset:end])
+	}
+	return b.Bytes()
+}
+
+func applyOverlay(original []uint8, overlay []uint8) ([]uint8, error) {
+	r := bytes.NewReader(overlay)
+	signature := make([]uint8, len(overlaySignature))
+	var checksum, size uint32
+	if _, err := r.Read(signature); err != nil || string(signature) != overlaySignature {
+		return nil, errors.New("not an overlay file")
+	}
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return nil, err
+	}
+	if checksum != crc32.ChecksumIEEE(original) {
+		return nil, errors.New("the overlay was made for a different image")
+	}
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	// The image grows only with the blocks in the overlay
+	if uint64(size) > uint64(len(original))+uint64(r.Len()) {
+		return nil, errors.New("the overlay is corrupted")
+	}
+
+	data := make([]uint8, size)
+	copy(data, original)
+	for r.Len() > 0 {
+		var offset, length uint32
+		if err := binary.Read(r, binary.BigEndian, &offset); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if uint64(offset)+uint64(length) > uint64(size) || int(length) > r.Len() {
+			return nil, errors.New("the overlay is corrupted")
+		}
+		r.Read(data[offset : offset+length])
+	}
+	return data, nil
+}
@@ -0,0 +1,199 @@
+package izatom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func overlayTestImage(size int) []uint8 {
+	image := make([]uint8, size)
+	for i := range image {
+		image[i] = uint8(i * 13)
+	}
+	return image
+}
+
+func TestOverlayRoundTrip(t *testing.T) {
+	original := overlayTestImage(4 * overlayBlockSize)
+	changes := []struct {
+		name   string
+		change func([]uint8) []uint8
+		blocks int
+	}{
+		{"unchanged", func(d []uint8) []uint8 { return d }, 0},
+		{"one byte", func(d []uint8) []uint8 { d[overlayBlockSize+5] ^= 0xff; return d }, 1},
+		{"two blocks", func(d []uint8) []uint8 { d[0] ^= 0xff; d[len(d)-1] ^= 0xff; return d }, 2},
+		{"grown", func(d []uint8) []uint8 { return append(d, overlayTestImage(overlayBlockSize+10)...) }, 2},
+		{"grown with zeros", func(d []uint8) []uint8 { return append(d, make([]uint8, overlayBlockSize)...) }, 1},
+		{"shrunk", func(d []uint8) []uint8 { return d[:2*overlayBlockSize] }, 0},
+	}
+	for _, c := range changes {
+		changed := c.change(append([]uint8(nil), original...))
+		overlay := makeOverlay(original, changed)
+		headerSize := len(overlaySignature) + 8
+		blockSize := 8 + overlayBlockSize
+		if blocks := (len(overlay) - headerSize + blockSize - 1) / blockSize; blocks != c.blocks {
+			t.Errorf("%v: the overlay has %v blocks, not %v", c.name, blocks, c.blocks)
+		}
+		data, err := applyOverlay(original, overlay)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(data, changed) {
+			t.Errorf("%v: the image with the overlay is not the changed one", c.name)
+		}
+	}
+}
+
+func TestOverlayErrors(t *testing.T) {
+	original := overlayTestImage(4 * overlayBlockSize)
+	changed := append([]uint8(nil), original...)
+	changed[0] ^= 0xff
+	valid := makeOverlay(original, changed)
+	headerSize := len(overlaySignature) + 8
+
+	overlays := []struct {
+		name    string
+		overlay []uint8
+		image   []uint8
+	}{
+		{"other image", valid, overlayTestImage(4*overlayBlockSize + 1)},
+		{"no signature", append([]uint8("IZOVRLY0"), valid[8:]...), original},
+		{"empty", nil, original},
+		{"truncated header", valid[:headerSize-2], original},
+		{"truncated block header", valid[:headerSize+6], original},
+		{"truncated block", valid[:len(valid)-1], original},
+		{"size too large", func() []uint8 {
+			o := append([]uint8(nil), valid...)
+			binary.BigEndian.PutUint32(o[headerSize-4:], 0xffffffff)
+			return o
+		}(), original},
+		{"block outside", func() []uint8 {
+			o := append([]uint8(nil), valid...)
+			binary.BigEndian.PutUint32(o[headerSize:], uint32(len(changed)-10))
+			return o
+		}(), original},
+		{"block length outside", func() []uint8 {
+			o := append([]uint8(nil), valid...)
+			binary.BigEndian.PutUint32(o[headerSize+4:], 0xffffffff)
+			return o
+		}(), original},
+	}
+	for _, o := range overlays {
+		_, err := applyOverlay(o.image, o.overlay)
+		if err == nil {
+			t.Errorf("%v: no error", o.name)
+		}
+	}
+}
+
+// Changes a byte of the disk on the drive 0 and saves it
+func changeDisk(t *testing.T, a *Atom, offset int) {
+	t.Helper()
+	writeDisk(a, offset, a.fdc.drives[0].disk.image()[offset]^0xff)
+	err := a.fdc.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadWithOverlay(t *testing.T, path string) *Atom {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.LoadDiskWithOptions(path, DiskOptions{Overlay: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestCommitAndDiscardOverlay(t *testing.T) {
+	fixture := overlayTestImage(2 * diskSectorsPerTrack * diskSectorSize)
+	path := filepath.Join(t.TempDir(), "overlay.40t")
+	err := os.WriteFile(path, fixture, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles := func(image []uint8, sidecar bool) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, image) {
+			t.Error("the image file has not the expected contents")
+		}
+		_, err = os.Stat(path + overlaySuffix)
+		if sidecar != (err == nil) {
+			t.Errorf("the sidecar exists is %v, not %v", err == nil, sidecar)
+		}
+	}
+
+	// The changes go to the sidecar, and are there when loaded again
+	a := loadWithOverlay(t, path)
+	changeDisk(t, a, 0x300)
+	checkFiles(fixture, true)
+	changed := append([]uint8(nil), a.fdc.drives[0].disk.image()...)
+	a = loadWithOverlay(t, path)
+	if !bytes.Equal(a.fdc.drives[0].disk.image(), changed) {
+		t.Error("the overlay is not applied when loaded again")
+	}
+
+	// Discarded, the disk is the image again
+	err = a.DiscardOverlay(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(fixture, false)
+	if !bytes.Equal(a.fdc.drives[0].disk.image(), fixture) {
+		t.Error("the disk is not reverted to the image")
+	}
+
+	// Committed, the image has the changes
+	changeDisk(t, a, 0x301)
+	changed = append([]uint8(nil), a.fdc.drives[0].disk.image()...)
+	err = a.CommitOverlay(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(changed, false)
+
+	// Without changes after the commit there is no sidecar
+	changeDisk(t, a, 0x302)
+	changeDisk(t, a, 0x302)
+	checkFiles(changed, false)
+
+	if err = a.CommitOverlay(1); err == nil {
+		t.Error("commit on a drive without overlay")
+	}
+}
+
+func TestLoadDiskSavesTheOverlayReplaced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overlay.40t")
+	fixture := overlayTestImage(2 * diskSectorsPerTrack * diskSectorSize)
+	err := os.WriteFile(path, fixture, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := loadWithOverlay(t, path)
+	writeDisk(a, 0x300, 0x5a)
+
+	err = a.LoadDisk(blankImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readOverlay(path, fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0x300] != 0x5a {
+		t.Error("the change is not saved on the sidecar of the disk replaced")
+	}
+}
@@ -0,0 +1,100 @@
+package izatom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+SCP images of the SuperCard Pro, with the flux transitions as captured
+from several revolutions. The header has:
+	0x00 "SCP"
+	0x05 Number of revolutions
+	0x06 First track
+	0x07 Last track
+	0x09 Bit cell width, 0 for 16 bits
+	0x0a Heads, 0 for both, 1 for side 0 only, 2 for side 1 only
+	0x0b Resolution, in 25ns units minus one
+	0x10 Offsets of the tracks, 32 bits little endian
+With both heads the track entries are the cylinder times 2 plus the head,
+with a single head they are the cylinder. Each track has "TRK", the track
+number and for each revolution the index time, the number of flux
+transitions and the offset of the transitions from the track header. The
+transitions are 16 bits big endian intervals, 0 adds 65536 to the next.
+*/
+
+const (
+	scpSignature   = "SCP"
+	scpHeaderSize  = 0x10
+	scpMaxTracks   = 168
+	scpMaxDecoding = 5 // Revolutions used to find the weak bits
+)
+
+func loadSCP(data []uint8) (floppyDisk, error) {
+	if len(data) < scpHeaderSize+scpMaxTracks*4 {
+		return nil, fmt.Errorf("the SCP header is incomplete")
+	}
+	revolutions := int(data[0x05])
+	firstTrack := int(data[0x06])
+	lastTrack := int(data[0x07])
+	cellWidth := data[0x09]
+	heads := data[0x0a]
+	resolution := float64(data[0x0b]+1) * 0.025 // In microseconds
+	if cellWidth != 0 && cellWidth != 16 {
+		return nil, fmt.Errorf("SCP bit cell width %v not supported", cellWidth)
+	}
+	if heads == 2 {
+		return nil, fmt.Errorf("the SCP image has only side 1")
+	}
+	if revolutions > scpMaxDecoding {
+		revolutions = scpMaxDecoding
+	}
+
+	disk := fluxDisk{data: data}
+	for entry := firstTrack; entry <= lastTrack && entry < scpMaxTracks; entry++ {
+		if heads == 0 && entry%2 != 0 {
+			continue // Side 1
+		}
+		cylinder := entry
+		if heads == 0 {
+			cylinder = entry / 2
+		}
+		for len(disk.tracks) < cylinder {
+			disk.tracks = append(disk.tracks, nil)
+		}
+
+		offset := int(binary.LittleEndian.Uint32(data[scpHeaderSize+entry*4:]))
+		if offset == 0 {
+			disk.tracks = append(disk.tracks, nil) // Not captured
+			continue
+		}
+		if offset+4+revolutions*12 > len(data) || string(data[offset:offset+3]) != "TRK" {
+			return nil, fmt.Errorf("the SCP track %v is not valid", entry)
+		}
+
+		var decoded []fluxRevolution
+		for r := 0; r < revolutions; r++ {
+			header := data[offset+4+r*12:]
+			count := int(binary.LittleEndian.Uint32(header[4:]))
+			start := offset + int(binary.LittleEndian.Uint32(header[8:]))
+			if start+count*2 > len(data) {
+				return nil, fmt.Errorf("the SCP track %v is outside of the file", entry)
+			}
+
+			var revolution fluxRevolution
+			carry := 0
+			for i := 0; i < count; i++ {
+				v := int(binary.BigEndian.Uint16(data[start+i*2:]))
+				if v == 0 {
+					carry += 0x10000
+					continue
+				}
+				revolution = append(revolution, float64(carry+v)*resolution)
+				carry = 0
+			}
+			decoded = append(decoded, revolution)
+		}
+		disk.tracks = append(disk.tracks, decodeRevolutions(decoded))
+	}
+	return &disk, nil
+}
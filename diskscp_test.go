@@ -0,0 +1,131 @@
+package izatom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const scpTestTicks = 160 // 4us, a half cell, in units of 25ns
+
+/*
+Returns an SCP image with the revolutions of half cells on the track
+entries. The first transition of each revolution is after more than
+65536 ticks, to have the 0 that adds to the next interval.
+*/
+func makeSCP(heads uint8, entries map[int][][]bool) []uint8 {
+	revolutions := 0
+	lastTrack := 0
+	for entry, revs := range entries {
+		revolutions = len(revs)
+		if entry > lastTrack {
+			lastTrack = entry
+		}
+	}
+
+	data := make([]uint8, scpHeaderSize+scpMaxTracks*4)
+	copy(data, scpSignature)
+	data[0x03] = 0x19 // Version
+	data[0x05] = uint8(revolutions)
+	data[0x06] = 0
+	data[0x07] = uint8(lastTrack)
+	data[0x09] = 0 // 16 bits
+	data[0x0a] = heads
+	data[0x0b] = 0 // 25ns
+
+	for entry := 0; entry <= lastTrack; entry++ {
+		revs, ok := entries[entry]
+		if !ok {
+			continue
+		}
+		offset := len(data)
+		binary.LittleEndian.PutUint32(data[scpHeaderSize+entry*4:], uint32(offset))
+		track := append([]uint8("TRK"), uint8(entry))
+		track = append(track, make([]uint8, len(revs)*12)...)
+		for r, cells := range revs {
+			var flux []uint8
+			ticks := 70000
+			for _, cell := range cells {
+				ticks += scpTestTicks
+				if !cell {
+					continue
+				}
+				for ; ticks >= 0x10000; ticks -= 0x10000 {
+					flux = append(flux, 0, 0)
+				}
+				flux = binary.BigEndian.AppendUint16(flux, uint16(ticks))
+				ticks = 0
+			}
+			header := track[4+r*12:]
+			binary.LittleEndian.PutUint32(header, uint32(len(cells)*scpTestTicks))
+			binary.LittleEndian.PutUint32(header[4:], uint32(len(flux)/2))
+			binary.LittleEndian.PutUint32(header[8:], uint32(len(track)))
+			track = append(track, flux...)
+		}
+		data = append(data, track...)
+	}
+	return data
+}
+
+func TestLoadSCP(t *testing.T) {
+	sectors := fmTestSectors()
+	first := fmEncodeTrack(sectors)
+	sectors[2].data[0] ^= 0x01
+	second := fmEncodeTrack(sectors)
+	sectors[2].data[0] ^= 0x01
+
+	disk, err := newFloppyDisk("test.scp", makeSCP(1, map[int][][]bool{0: {first, second}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.isWritable() {
+		t.Error("the SCP image is writable")
+	}
+	track := disk.track(0)
+	checkFMTrack(t, track, sectors)
+	if weak := track.sectors[2].weak; weak == nil || !weak[0] {
+		t.Error("the first byte of the third sector is not weak")
+	}
+}
+
+func TestLoadSCPBothSides(t *testing.T) {
+	side0 := fmTestSectors()
+	side1 := fmTestSectors()[:1]
+	cylinder1 := fmTestSectors()
+	for i := range cylinder1 {
+		cylinder1[i].id[0] = 1
+	}
+
+	disk, err := newFloppyDisk("test.scp", makeSCP(0, map[int][][]bool{
+		0: {fmEncodeTrack(side0)},
+		1: {fmEncodeTrack(side1)},
+		2: {fmEncodeTrack(cylinder1)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFMTrack(t, disk.track(0), side0)
+	checkFMTrack(t, disk.track(1), cylinder1)
+}
+
+func TestLoadSCPErrors(t *testing.T) {
+	valid := func() []uint8 {
+		return makeSCP(1, map[int][][]bool{0: {fmEncodeTrack(fmTestSectors())}})
+	}
+	images := []struct {
+		name string
+		data []uint8
+	}{
+		{"truncated header", valid()[:100]},
+		{"cell width", func() []uint8 { d := valid(); d[0x09] = 8; return d }()},
+		{"only side 1", func() []uint8 { d := valid(); d[0x0a] = 2; return d }()},
+		{"no track header", func() []uint8 { d := valid(); d[scpHeaderSize+scpMaxTracks*4] = 'X'; return d }()},
+		{"truncated track", valid()[:scpHeaderSize+scpMaxTracks*4+100]},
+		{"no signature", valid()[3:]},
+	}
+	for _, image := range images {
+		_, err := newFloppyDisk("test.scp", image.data)
+		if err == nil {
+			t.Errorf("%v: no error", image.name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package izatom
+
+import "math"
+
+/*
+Synthesized sounds of the disk drive, mixed with the speaker: a click
+for each step of the head and a low hum while the motor is on. They are
+off by default, see SetDriveSounds.
+*/
+
+const (
+	clickSamples   = AudioSampleRate * 4 / 1000 // 4ms
+	clickFrequency = 1800
+	clickVolume    = 0x1800
+	humFrequency   = 50 // Hz, with a 5Hz wobble of the 300 rpm rotation
+	humVolume      = 0x0300
+)
+
+type driveSounds struct {
+	enabled bool
+	clicks  []uint64 // Samples where the clicks started, while they sound
+}
+
+// SetDriveSounds adds the drive sounds to the audio of the frames
+func (a *Atom) SetDriveSounds(enabled bool) {
+	a.configure(func() {
+		a.sounds.enabled = enabled
+	})
+}
+
+/*
+Mixes the sounds on the samples of a frame, starting with the sample
+number first. The steps are the cycles of the head steps.
+*/
+func (s *driveSounds) mix(samples []int16, first uint64, steps []uint64, motor bool) {
+	if !s.enabled {
+		s.clicks = s.clicks[:0]
+		return
+	}
+	for _, cycle := range steps {
+		s.clicks = append(s.clicks, cycle*AudioSampleRate/cpuCyclesPerSecond)
+	}
+
+	for i := range samples {
+		n := first + uint64(i)
+		value := float64(samples[i])
+		for _, start := range s.clicks {
+			if n >= start && n < start+clickSamples {
+				t := float64(n-start) / AudioSampleRate
+				decay := 1 - float64(n-start)/clickSamples
+				value += clickVolume * decay * math.Sin(2*math.Pi*clickFrequency*t)
+			}
+		}
+		if motor {
+			t := float64(n) / AudioSampleRate
+			wobble := 0.75 + 0.25*math.Sin(2*math.Pi*5*t)
+			value += humVolume * wobble * math.Sin(2*math.Pi*humFrequency*t)
+		}
+		samples[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, value)))
+	}
+
+	// Keep the clicks still sounding on the next frame
+	end := first + uint64(len(samples))
+	sounding := s.clicks[:0]
+	for _, start := range s.clicks {
+		if start+clickSamples > end {
+			sounding = append(sounding, start)
+		}
+	}
+	s.clicks = sounding
+}
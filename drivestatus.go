@@ -0,0 +1,37 @@
+package izatom
+
+// DriveStatus is the state of a disk drive at the end of the last frame
+type DriveStatus struct {
+	HasDisk        bool
+	WriteProtected bool
+	Motor          bool
+	Track          int  // Position of the head
+	Reading        bool // Data was read during the frame
+	Writing        bool // Data was written during the frame
+}
+
+// DriveStatus returns the state of a drive, 0 or 1
+func (a *Atom) DriveStatus(drive int) DriveStatus {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	if drive < 0 || drive >= len(a.publishedDrives) {
+		return DriveStatus{}
+	}
+	return a.publishedDrives[drive]
+}
+
+func (a *Atom) captureDrives() {
+	for i := range a.fdc.drives {
+		d := &a.fdc.drives[i]
+		a.publishedDrives[i] = DriveStatus{
+			HasDisk:        d.disk != nil,
+			WriteProtected: d.isWriteProtected(),
+			Motor:          d.motorOn,
+			Track:          int(d.track),
+			Reading:        d.reading,
+			Writing:        d.writing,
+		}
+		d.reading = false
+		d.writing = false
+	}
+}
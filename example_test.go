@@ -0,0 +1,144 @@
+package izatom_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ivanizag/izatom"
+)
+
+// ram is RAM on the expansion bus, as the boards that fill the Atom map
+type ram struct {
+	name string
+	data []uint8
+}
+
+func (r *ram) Name() string                     { return r.name }
+func (r *ram) Read(offset uint16) uint8         { return r.data[offset] }
+func (r *ram) Peek(offset uint16) uint8         { return r.data[offset] }
+func (r *ram) Write(offset uint16, value uint8) { r.data[offset] = value }
+func (r *ram) Tick(cycle uint64)                {}
+func (r *ram) Reset()                           {}
+func (r *ram) IRQ() bool                        { return false }
+func (r *ram) NMI() bool                        { return false }
+func (r *ram) SaveState() ([]byte, error)       { return append([]byte(nil), r.data...), nil }
+
+func (r *ram) LoadState(state []byte) error {
+	if len(state) != len(r.data) {
+		return fmt.Errorf("the state of %v is %v bytes, not %v", r.name, len(state), len(r.data))
+	}
+	copy(r.data, state)
+	return nil
+}
+
+// Prints the lines of the memory map from an address to another
+func printMap(a *izatom.Atom, from string, to string) {
+	lines := strings.Split(a.MemoryMap(), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, from) {
+			for _, line := range lines[i:] {
+				fmt.Println(line)
+				if strings.HasPrefix(line, to) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Extra RAM replacing the utility ROM on #A000-#AFFF
+func ExampleAtom_AttachDevice() {
+	a, err := izatom.NewAtom()
+	if err != nil {
+		panic(err)
+	}
+	err = a.AttachDevice(&ram{"extra RAM", make([]uint8, 0x1000)},
+		izatom.DeviceArea{Start: 0xa000, End: 0xafff, Mask: 0x0fff})
+	if err != nil {
+		panic(err)
+	}
+
+	a.Poke(0xa123, 0x5a)
+	fmt.Printf("#%02X\n", a.Peek(0xa123))
+	printMap(a, "#A000", "#A000")
+	// Output:
+	// #5A
+	// #A000-#AFFF extra RAM
+}
+
+/*
+pagedROM has several ROMs for #A000-#AFFF, the one selected with the
+latch at #BFFF. The latch is a second device, as the areas are decoded
+by page it takes #BF00-#BFFF, over the VIA mirrors.
+*/
+type pagedROM struct {
+	roms     [][]uint8
+	selected int
+}
+
+func (p *pagedROM) Name() string                     { return "paged ROM" }
+func (p *pagedROM) Read(offset uint16) uint8         { return p.roms[p.selected][offset] }
+func (p *pagedROM) Peek(offset uint16) uint8         { return p.Read(offset) }
+func (p *pagedROM) Write(offset uint16, value uint8) {} // Read only
+func (p *pagedROM) Tick(cycle uint64)                {}
+func (p *pagedROM) Reset()                           { p.selected = 0 }
+func (p *pagedROM) IRQ() bool                        { return false }
+func (p *pagedROM) NMI() bool                        { return false }
+func (p *pagedROM) SaveState() ([]byte, error)       { return []byte{uint8(p.selected)}, nil }
+
+func (p *pagedROM) LoadState(state []byte) error {
+	if len(state) != 1 || int(state[0]) >= len(p.roms) {
+		return fmt.Errorf("invalid state of the paged ROM")
+	}
+	p.selected = int(state[0])
+	return nil
+}
+
+type pagedROMLatch struct {
+	rom *pagedROM
+}
+
+func (l *pagedROMLatch) Name() string             { return "paged ROM latch" }
+func (l *pagedROMLatch) Read(offset uint16) uint8 { return uint8(l.rom.selected) }
+func (l *pagedROMLatch) Peek(offset uint16) uint8 { return l.Read(offset) }
+func (l *pagedROMLatch) Write(offset uint16, value uint8) {
+	l.rom.selected = int(value) % len(l.rom.roms)
+}
+func (l *pagedROMLatch) Tick(cycle uint64)            {}
+func (l *pagedROMLatch) Reset()                       {}
+func (l *pagedROMLatch) IRQ() bool                    { return false }
+func (l *pagedROMLatch) NMI() bool                    { return false }
+func (l *pagedROMLatch) SaveState() ([]byte, error)   { return nil, nil } // On the paged ROM
+func (l *pagedROMLatch) LoadState(state []byte) error { return nil }
+
+// Two ROMs paged on #A000-#AFFF
+func ExampleAtom_AttachDevice_pagedROM() {
+	a, err := izatom.NewAtom()
+	if err != nil {
+		panic(err)
+	}
+	rom := &pagedROM{roms: [][]uint8{make([]uint8, 0x1000), make([]uint8, 0x1000)}}
+	copy(rom.roms[0], "FIRST")
+	copy(rom.roms[1], "SECOND")
+	err = a.AttachDevice(rom, izatom.DeviceArea{Start: 0xa000, End: 0xafff, Mask: 0x0fff})
+	if err != nil {
+		panic(err)
+	}
+	err = a.AttachDevice(&pagedROMLatch{rom}, izatom.DeviceArea{Start: 0xbf00, End: 0xbfff, Mask: 0})
+	if err != nil {
+		panic(err)
+	}
+
+	for bank := uint8(0); bank < 2; bank++ {
+		a.Poke(0xbfff, bank)
+		fmt.Printf("%c\n", a.Peek(0xa000))
+	}
+	printMap(a, "#A000", "#BF00")
+	// Output:
+	// F
+	// S
+	// #A000-#AFFF paged ROM
+	// #B000-#B7FF 8255 PPIA, mask #0003
+	// #B800-#BEFF 6522 VIA, mask #000F
+	// #BF00-#BFFF paged ROM latch, mask #0000
+}
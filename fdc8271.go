@@ -18,40 +18,181 @@ See the FDC 8271 datasheet for more information.
 Disks are single side and single density
 256 bytes per sector, 40 tracks, 92kb per disk
 
+The INT output of the 8271 is connected to the NMI of the 6502. DOS uses
+the non DMA mode: the NMI handler moves a byte on each data request and
+calls the completion routine when the result is ready.
 
-TODO / BUGS:
- . When DOS loads a file that span two tracks, only the sectors from
- the first track are loaded.
+The disks rotate at 300 rpm, 200ms per revolution, with an index pulse
+at the start of each revolution. The data is FM at 125 kbit/s, a byte
+every 64us. The sectors of the images are laid out on the track as a
+DOS format would do, see sectorIDPosition. The drive is ready after the
+motor is on for two revolutions. The step rate, the head settle and load
+times and the index count to unload the head are set with SPECIFY.
+
+All the commands of the 8271 are supported on the track model of disk.go.
 */
 
+const (
+	fdcStatusBusy        = 0x80
+	fdcStatusCommandFull = 0x40
+	fdcStatusParamFull   = 0x20
+	fdcStatusResultFull  = 0x10
+	fdcStatusInterrupt   = 0x08
+	fdcStatusNonDMA      = 0x04 // Non DMA data request
+
+	fdcResultOK             = 0x00 // Also scan met equal
+	fdcResultScanNotMet     = 0x04
+	fdcResultLateDMA        = 0x0a
+	fdcResultIDCRC          = 0x0c
+	fdcResultDataCRC        = 0x0e
+	fdcResultNotReady       = 0x10
+	fdcResultWriteProtect   = 0x12
+	fdcResultSectorNotFound = 0x18
+	fdcResultDeletedData    = 0x20 // Added to the others
+
+	fdcDriveStatusReady1       = 0x40
+	fdcDriveStatusIndex        = 0x10
+	fdcDriveStatusWriteProtect = 0x08
+	fdcDriveStatusReady0       = 0x04
+	fdcDriveStatusTrack0       = 0x02
+
+	fdcOutputSelect0  = 0x40
+	fdcOutputSelect1  = 0x80
+	fdcOutputLoadHead = 0x08
+)
+
+const (
+	fdcMsCycles          = 1000    // At 1 MHz
+	fdcRevolutionCycles  = 200_000 // 300 rpm
+	fdcIndexPulseCycles  = 4 * fdcMsCycles
+	fdcSpinUpCycles      = 2 * fdcRevolutionCycles
+	fdcByteCycles        = 64 // FM, 125 kbit/s
+	fdcNotFoundIndexes   = 2  // Revolutions looking for a sector
+	fdcCommandDelayCycle = 100
+)
+
+type fdcCommand struct {
+	name   string
+	params int
+}
+
+// Commands of the 8271, the drive select bits removed
+var fdcCommands = map[uint8]fdcCommand{
+	0x00: {"Scan data", 5},
+	0x04: {"Scan data and deleted data", 5},
+	0x0a: {"Write data 128", 2},
+	0x0b: {"Write data", 3},
+	0x0e: {"Write deleted data 128", 2},
+	0x0f: {"Write deleted data", 3},
+	0x12: {"Read data 128", 2},
+	0x13: {"Read data", 3},
+	0x16: {"Read data and deleted data 128", 2},
+	0x17: {"Read data and deleted data", 3},
+	0x1b: {"Read ID", 3},
+	0x1e: {"Verify data and deleted data 128", 2},
+	0x1f: {"Verify data and deleted data", 3},
+	0x23: {"Format track", 5},
+	0x29: {"Seek", 1},
+	0x2c: {"Read drive status", 0},
+	0x35: {"Specify", 4},
+	0x3a: {"Write special register", 2},
+	0x3d: {"Read special register", 1},
+}
+
+// What is done with the bytes of the sectors
+type fdcTransfer int
+
+const (
+	transferRead   fdcTransfer = iota
+	transferWrite              // The bytes are requested to the CPU and written
+	transferVerify             // The CRC is checked, nothing is moved
+	transferScan               // The bytes are requested to the CPU and compared
+)
+
+type floppyDrive struct {
+	disk  floppyDisk // nil if there is no disk
+	path  string
+	dirty bool // The disk has changes not saved to the file
+
+	writeProtect bool
+	overlay      bool    // The changes are saved on a sidecar file
+	original     []uint8 // The image file, with overlay
+	inMemory     bool    // The changes are not saved, for the movies
+
+	track     uint8 // Position of the head
+	motorOn   bool
+	motorTime uint64 // Cycle when the motor was started
+
+	// Activity since the last capture of the status
+	reading bool
+	writing bool
+}
+
 type fdc8271 struct {
 	a   *Atom
 	log bool
 
-	command  uint8
-	status   uint8
-	result   uint8
-	register uint8
-	param    uint8
+	command uint8
+	params  []uint8
+	status  uint8
+	result  uint8
+	data    uint8
+
+	// Set by SPECIFY and WRITE SPECIAL REGISTER
+	stepCycles       uint64
+	settleCycles     uint64
+	headLoadCycles   uint64
+	unloadIndexCount uint8
+	badTracks        [2][2]uint8
+	currentTrack     [2]uint8 // The track the 8271 thinks each surface is on
+	mode             uint8
+	outputPort       uint8
+
+	drives      [2]floppyDrive
+	drive       int // Selected drive
+	headLoaded  bool
+	idleIndexes uint8
+	revolution  uint64
 
-	select0      bool
-	select0delay uint8
+	// Operation in progress
+	eventCycle    uint64
+	event         func() // nil if there is nothing scheduled
+	inEvent       bool
+	track         uint8
+	sector        uint8
+	sectorCount   uint8
+	sectorSize    int
+	sectorStep    uint8
+	transfer      fdcTransfer
+	transferIndex int
+	requestBytes  int // Bytes of each sector moved with the CPU
+	searchIndexes int
+	formatGap1    int
+	formatGap3    int
+	formatID      [4]uint8
 
-	track       uint8
-	sector      uint8
-	sectorCount uint8
+	acceptDeleted bool
+	deletedFound  bool
 
-	index                int
-	readEnd              int
-	nextByte             uint8
-	raiseNMIDelayedCycle uint64
+	// Results of the last scan
+	scanMatch  bool
+	scanSector uint8
 
-	data []uint8
+	randomState uint32
+
+	steps []uint64 // Cycles of the head steps, for the drive sounds
+
+	activityCycle uint64
 }
 
 func NewFDC8271(a *Atom) *fdc8271 {
 	var fdc fdc8271
 	fdc.a = a
+	fdc.randomState = 1
+	fdc.specifyInit(0x14, 0x05, 0xca) // As DOS does
+	for i := range fdc.badTracks {
+		fdc.badTracks[i] = [2]uint8{0xff, 0xff}
+	}
 	return &fdc
 }
 
@@ -61,169 +202,814 @@ func (fdc *fdc8271) logf(format string, a ...interface{}) {
 	}
 }
 
+func (fdc *fdc8271) cycle() uint64 {
+	return fdc.a.cpu.GetCycles()
+}
+
 func (fdc *fdc8271) tick(cycle uint64) {
-	if fdc.select0delay > 0 {
-		fdc.select0delay--
-		if fdc.select0delay == 0 {
-			fdc.logf("Drive 0 ready\n")
-			fdc.select0 = true
+	revolution := cycle / fdcRevolutionCycles
+	if revolution != fdc.revolution {
+		fdc.revolution = revolution
+		fdc.indexPulse()
+	}
+
+	if fdc.event != nil && cycle >= fdc.eventCycle {
+		event := fdc.event
+		fdc.event = nil
+		fdc.inEvent = true
+		event()
+		fdc.inEvent = false
+	}
+}
+
+// The cycle of the event running, to not drift with the instructions
+func (fdc *fdc8271) now() uint64 {
+	if fdc.inEvent {
+		return fdc.eventCycle
+	}
+	return fdc.cycle()
+}
+
+// Runs the event after the delay in cycles
+func (fdc *fdc8271) after(delay uint64, event func()) {
+	fdc.eventCycle = fdc.now() + delay
+	fdc.event = event
+}
+
+func (fdc *fdc8271) indexPulse() {
+	if fdc.status&fdcStatusBusy != 0 {
+		fdc.searchIndexes++
+		return
+	}
+	if fdc.headLoaded && fdc.unloadIndexCount != 0x0f {
+		fdc.idleIndexes++
+		if fdc.idleIndexes >= fdc.unloadIndexCount {
+			fdc.logf("Head unloaded\n")
+			fdc.headLoaded = false
+			fdc.outputPort &^= fdcOutputSelect0 | fdcOutputSelect1 | fdcOutputLoadHead
+			fdc.updateMotors()
 		}
 	}
+}
 
-	if fdc.raiseNMIDelayedCycle > 0 && cycle >= fdc.raiseNMIDelayedCycle {
+/*
+The disk is busy while a command is executing and for a while after the
+last access, as DOS issues several commands to load a file.
+*/
+const fdcBusyCycles = 200_000
 
-		if fdc.index == fdc.readEnd {
-			// We are done
-			fdc.status = 0x00 /* not busy */ |
-				0x10 /* Result full */ |
-				0x08 /* Interrupt request */
-			fdc.result = 0x00
-		} else {
-			fdc.nextByte = fdc.data[fdc.index]
-			fdc.status = 0x80 /* busy */ |
-				0x08 /* Interrupt request */ |
-				0x04 /* Non DMA mode */
-			fdc.index++
+func (fdc *fdc8271) isBusy(cycle uint64) bool {
+	return fdc.status&fdcStatusBusy != 0 ||
+		(fdc.activityCycle != 0 && cycle < fdc.activityCycle+fdcBusyCycles)
+}
+
+func (fdc *fdc8271) interrupt() {
+	fdc.status |= fdcStatusInterrupt
+	fdc.a.cpu.RaiseNMI()
+}
+
+// The motors are on while the drives are selected
+func (fdc *fdc8271) updateMotors() {
+	for i := range fdc.drives {
+		d := &fdc.drives[i]
+		on := fdc.outputPort&(fdcOutputSelect0<<i) != 0
+		if on && !d.motorOn {
+			d.motorTime = fdc.cycle()
 		}
-		// fdc.logf("[FDC] Raise NMI 0x%02x %v\n", fdc.status, fdc.index)
+		d.motorOn = on
+	}
+}
+
+func (d *floppyDrive) isWriteProtected() bool {
+	return d.disk != nil && (d.writeProtect || !d.disk.isWritable())
+}
 
-		fdc.raiseNMIDelayedCycle = 0
-		fdc.a.cpu.RaiseNMI()
+func (fdc *fdc8271) isMotorOn() bool {
+	return fdc.drives[0].motorOn || fdc.drives[1].motorOn
+}
+
+// Returns the steps since the last call. The slice is reused on the next call.
+func (fdc *fdc8271) takeSteps() []uint64 {
+	steps := fdc.steps
+	fdc.steps = fdc.steps[:0]
+	return steps
+}
+
+func (fdc *fdc8271) isReady(drive int) bool {
+	d := &fdc.drives[drive]
+	return d.disk != nil && d.motorOn && fdc.cycle() >= d.motorTime+fdcSpinUpCycles
+}
+
+func (fdc *fdc8271) isIndex(drive int) bool {
+	d := &fdc.drives[drive]
+	return d.disk != nil && d.motorOn && fdc.cycle()%fdcRevolutionCycles < fdcIndexPulseCycles
+}
+
+func (fdc *fdc8271) driveStatus() uint8 {
+	status := uint8(0x80)
+	if fdc.isReady(0) {
+		status |= fdcDriveStatusReady0
+	}
+	if fdc.isReady(1) {
+		status |= fdcDriveStatusReady1
+	}
+	if fdc.isIndex(fdc.drive) {
+		status |= fdcDriveStatusIndex
+	}
+	d := &fdc.drives[fdc.drive]
+	if d.isWriteProtected() {
+		status |= fdcDriveStatusWriteProtect
 	}
+	if d.track == 0 {
+		status |= fdcDriveStatusTrack0
+	}
+	return status
 }
 
-func (fdc *fdc8271) raiseNMIDelayed() {
-	fdc.raiseNMIDelayedCycle = fdc.a.cpu.GetCycles() + 400
+func (fdc *fdc8271) specifyInit(step uint8, settle uint8, load uint8) {
+	fdc.stepCycles = uint64(step) * fdcMsCycles
+	fdc.settleCycles = uint64(settle) * fdcMsCycles
+	fdc.headLoadCycles = uint64(load&0x0f) * 4 * fdcMsCycles
+	fdc.unloadIndexCount = load >> 4
+	fdc.logf("Step %vms, settle %vms, head load %vms, unload after %v indexes\n",
+		fdc.stepCycles/fdcMsCycles, fdc.settleCycles/fdcMsCycles,
+		fdc.headLoadCycles/fdcMsCycles, fdc.unloadIndexCount)
 }
 
 func (fdc *fdc8271) writeSpecialRegister(register uint8, value uint8) {
 	switch register {
+	case 0x10, 0x11: // Bad tracks surface 0
+		fdc.badTracks[0][register-0x10] = value
+	case 0x12: // Current track surface 0
+		fdc.currentTrack[0] = value
 	case 0x17: // Mode register
 		fdc.logf("Mode register 0x%02x: 0x%02x-%08b\n", register, value, value)
+		fdc.mode = value
+	case 0x18, 0x19: // Bad tracks surface 1
+		fdc.badTracks[1][register-0x18] = value
+	case 0x1a: // Current track surface 1
+		fdc.currentTrack[1] = value
 	case 0x23: // Drive control output port
 		fdc.logf("Drive control output port register 0x%02x: 0x%02x-%08b\n", register, value, value)
-		if (value & 0x40) != 0 {
-			fdc.logf("Drive 0 selected\n")
-			fdc.select0 = false
-			fdc.select0delay = 200
+		fdc.outputPort = value
+		if value&fdcOutputSelect1 != 0 && value&fdcOutputSelect0 == 0 {
+			fdc.drive = 1
+		} else if value&fdcOutputSelect0 != 0 {
+			fdc.drive = 0
 		}
+		fdc.headLoaded = value&fdcOutputLoadHead != 0
+		fdc.idleIndexes = 0
+		fdc.updateMotors()
 	default:
 		fdc.logf("Unknown special register 0x%02x: 0x%02x-%08b\n", register, value, value)
 	}
 }
 
+func (fdc *fdc8271) readSpecialRegister(register uint8) uint8 {
+	switch register {
+	case 0x06: // Scan sector
+		return fdc.scanSector
+	case 0x10, 0x11: // Bad tracks surface 0
+		return fdc.badTracks[0][register-0x10]
+	case 0x12: // Current track surface 0
+		return fdc.currentTrack[0]
+	case 0x13: // Scan count LSB
+		return fdc.sectorCount
+	case 0x14: // Scan count MSB
+		return 0
+	case 0x17: // Mode register
+		return fdc.mode
+	case 0x18, 0x19: // Bad tracks surface 1
+		return fdc.badTracks[1][register-0x18]
+	case 0x1a: // Current track surface 1
+		return fdc.currentTrack[1]
+	case 0x22: // Drive control input port
+		return fdc.driveStatus()
+	case 0x23: // Drive control output port
+		return fdc.outputPort
+	default:
+		fdc.logf("Unknown special register 0x%02x read\n", register)
+		return 0
+	}
+}
+
+/*
+The reset aborts the command in progress, unloads the head and deselects
+the drives. The values set with SPECIFY are kept, DOS sets them again
+after the reset anyway.
+*/
 func (fdc *fdc8271) reset() {
-	// TODO
+	fdc.status = 0
+	fdc.result = 0
+	fdc.params = fdc.params[:0]
+	fdc.event = nil
+	fdc.mode = 0
+	fdc.outputPort = 0
+	fdc.headLoaded = false
+	fdc.updateMotors()
 }
 
 func (fdc *fdc8271) write(port uint8, value uint8) {
+	fdc.activityCycle = fdc.cycle()
+
 	// Port is CS-A1-A0
 	switch port {
 	case 0:
-		fdc.command = value & 0x3f
-		fdc.param = 0
-		drive := value >> 6
-		switch fdc.command {
-		case 0x13: // READ DATA
-			fdc.logf("Read data drive %v\n", drive)
-		case 0x29: // SEEK
-			fdc.logf("Seek drive %v\n", drive)
-		case 0x2c: // READ DRIVE STATUS
-			fdc.logf("Read drive status %v\n", drive)
-			fdc.result = 0x80 | 0x10 /*index*/
-			if fdc.select0 {
-				fdc.result |= 0x04 /* ready 0 */
-			}
-			if fdc.track == 0 {
-				fdc.result |= 0x02 /* track 0 */
-			}
-		case 0x35: // SPECIFY
-			fdc.logf("Specify drive %v\n", drive)
-		case 0x3a: // WRITE SPECIAL REGISTER
-			fdc.logf("Write special register drive %v\n", drive)
-		default:
-			fdc.logf("Unknown command: Drive %v, Opcode 0x%02x-%06b\n", drive, fdc.command, fdc.command)
+		if fdc.status&fdcStatusBusy != 0 {
+			fdc.logf("Command 0x%02x ignored, busy\n", value)
+			return
+		}
+		fdc.command = value
+		fdc.params = fdc.params[:0]
+		fdc.status = fdcStatusBusy
+		command, ok := fdcCommands[fdc.command&0x3f]
+		if !ok {
+			fdc.logf("Unknown command: Opcode 0x%02x-%06b\n", fdc.command, fdc.command&0x3f)
+			fdc.status = 0
+			return
 		}
+		fdc.logf("%v: 0x%02x\n", command.name, fdc.command)
+		fdc.acceptParams()
 	case 1:
 		fdc.logf("Parameter: 0x%02x-%08b\n", value, value)
-		switch fdc.command {
-		case 0x13: // READ DATA
-			switch fdc.param {
-			case 0:
-				fdc.track = value
-			case 1:
-				fdc.sector = value
-			case 2:
-				fdc.sectorCount = value & 0x1f
-				fdc.logf("Multirecord parameters: track %v, sector %v, count %v, record size %v\n",
-					fdc.track, fdc.sector, fdc.sectorCount, 128*(1<<(value>>5)))
-				fdc.status = 0x80 /* busy */
-				fdc.index = 256*10*int(fdc.track) + 256*int(fdc.sector)
-				fdc.readEnd = fdc.index + 256*int(fdc.sectorCount)
-				if fdc.readEnd > len(fdc.data) {
-					fdc.readEnd = len(fdc.data)
-					//panic("Read beyond end of disk")
-				}
-
-				fdc.logf("Read data from %v to %v\n", fdc.index, fdc.readEnd)
-				fdc.raiseNMIDelayed()
-			}
-		case 0x29: // SEEK
-			fdc.track = value
-		case 0x3a: // WRITE SPECIAL REGISTER
-			switch fdc.param {
-			case 0:
-				fdc.register = value
-			case 1:
-				fdc.writeSpecialRegister(fdc.register, value)
-			}
+		if fdc.status&fdcStatusBusy == 0 || len(fdc.params) >= fdcCommands[fdc.command&0x3f].params {
+			return // No command waiting for parameters
 		}
-		fdc.param++
+		fdc.params = append(fdc.params, value)
+		fdc.acceptParams()
 	case 2:
-		fdc.status = 0x00
 		fdc.logf("Reset: %v\n", value)
+		if value&1 != 0 {
+			fdc.reset()
+		}
 	case 3:
 		fdc.logf("Do not use: %v\n", value)
 	default:
-		fdc.logf("Write data at %v: %v\n", port, value)
+		//fdc.logf("Write data at %v: %v\n", port, value)
+		fdc.data = value
+		fdc.status &^= fdcStatusNonDMA | fdcStatusInterrupt
+	}
+}
+
+// Starts the command when all the parameters are available
+func (fdc *fdc8271) acceptParams() {
+	if len(fdc.params) < fdcCommands[fdc.command&0x3f].params {
+		return
+	}
+
+	// The drive select bits in the command are output to the drives
+	if fdc.command&0xc0 != 0 {
+		fdc.outputPort = fdc.outputPort&^(fdcOutputSelect0|fdcOutputSelect1) | fdc.command&0xc0
+		fdc.drive = 0
+		if fdc.command&0xc0 == fdcOutputSelect1 {
+			fdc.drive = 1
+		}
+		fdc.updateMotors()
+	}
+	fdc.idleIndexes = 0
+	fdc.acceptDeleted = false
+	fdc.deletedFound = false
 
+	p := fdc.params
+	opcode := fdc.command & 0x3f
+	switch opcode {
+	case 0x00, 0x04: // SCAN DATA, SCAN DATA AND DELETED DATA
+		fdc.setSectors(p[0], p[1], p[2])
+		fdc.sectorStep = p[3]
+		fdc.requestBytes = int(p[4])
+		if fdc.requestBytes == 0 || fdc.requestBytes > fdc.sectorSize {
+			fdc.requestBytes = fdc.sectorSize
+		}
+		fdc.transfer = transferScan
+		fdc.acceptDeleted = opcode == 0x04
+		fdc.startOperation(fdc.transferSector)
+	case 0x0a, 0x0e, 0x12, 0x16, 0x1e: // The 128 bytes variants
+		fdc.setSectors(p[0], p[1], 0x01)
+		fdc.startSectors(opcode | 0x01)
+	case 0x0b, 0x0f, 0x13, 0x17, 0x1f: // WRITE, READ and VERIFY
+		fdc.setSectors(p[0], p[1], p[2])
+		fdc.startSectors(opcode)
+	case 0x1b: // READ ID
+		fdc.track = p[0]
+		fdc.sectorCount = p[2]
+		fdc.startOperation(fdc.readIDs)
+	case 0x23: // FORMAT TRACK
+		fdc.setSectors(p[0], 0, p[2])
+		fdc.formatGap3 = int(p[1])
+		fdc.formatGap1 = int(p[4])
+		fdc.startOperation(fdc.formatTrack)
+	case 0x29: // SEEK
+		fdc.track = p[0]
+		fdc.logf("Seek drive %v, track %v\n", fdc.drive, fdc.track)
+		fdc.startOperation(func() { fdc.finish(fdcResultOK) })
+	case 0x2c: // READ DRIVE STATUS
+		fdc.result = fdc.driveStatus()
+		fdc.logf("Read drive status %v: 0x%02x\n", fdc.drive, fdc.result)
+		fdc.status = fdcStatusResultFull
+	case 0x35: // SPECIFY
+		fdc.logf("Specify 0x%02x\n", p[0])
+		switch p[0] {
+		case 0x0d: // Initialization
+			fdc.specifyInit(p[1], p[2], p[3])
+		case 0x10: // Load bad tracks surface 0
+			fdc.badTracks[0] = [2]uint8{p[1], p[2]}
+			fdc.currentTrack[0] = p[3]
+		case 0x18: // Load bad tracks surface 1
+			fdc.badTracks[1] = [2]uint8{p[1], p[2]}
+			fdc.currentTrack[1] = p[3]
+		}
+		fdc.status = 0
+	case 0x3a: // WRITE SPECIAL REGISTER
+		fdc.logf("Write special register 0x%02x: 0x%02x\n", p[0], p[1])
+		fdc.writeSpecialRegister(p[0], p[1])
+		fdc.status = 0
+	case 0x3d: // READ SPECIAL REGISTER
+		fdc.result = fdc.readSpecialRegister(p[0])
+		fdc.logf("Read special register 0x%02x: 0x%02x\n", p[0], fdc.result)
+		fdc.status = fdcStatusResultFull
+	}
+}
+
+// Sets the sectors of the command from the track, sector and size|count parameters
+func (fdc *fdc8271) setSectors(track uint8, sector uint8, sizeCount uint8) {
+	fdc.track = track
+	fdc.sector = sector
+	fdc.sectorCount = sizeCount & 0x1f
+	fdc.sectorSize = 128 << (sizeCount >> 5)
+	fdc.sectorStep = 1
+	fdc.logf("Drive %v, track %v, sector %v, count %v, record size %v\n",
+		fdc.drive, fdc.track, fdc.sector, fdc.sectorCount, fdc.sectorSize)
+}
+
+func (fdc *fdc8271) startSectors(opcode uint8) {
+	switch opcode {
+	case 0x0b, 0x0f:
+		fdc.transfer = transferWrite
+		fdc.requestBytes = fdc.sectorSize
+	case 0x13, 0x17:
+		fdc.transfer = transferRead
+		fdc.requestBytes = fdc.sectorSize
+	case 0x1f:
+		fdc.transfer = transferVerify
+		fdc.requestBytes = 0
+	}
+	fdc.acceptDeleted = opcode == 0x17 || opcode == 0x1f
+	fdc.startOperation(fdc.transferSector)
+}
+
+// The command in progress writes to the disk
+func (fdc *fdc8271) isWriting() bool {
+	switch fdc.command & 0x3f {
+	case 0x0a, 0x0b, 0x0e, 0x0f, 0x23:
+		return true
+	}
+	return false
+}
+
+// Loads the head and seeks the track of the command, then runs next
+func (fdc *fdc8271) startOperation(next func()) {
+	if !fdc.isReady(fdc.drive) {
+		fdc.after(fdcCommandDelayCycle, func() { fdc.finish(fdcResultNotReady) })
+		return
+	}
+	if fdc.isWriting() && fdc.drives[fdc.drive].isWriteProtected() {
+		fdc.after(fdcCommandDelayCycle, func() { fdc.finish(fdcResultWriteProtect) })
+		return
 	}
 
+	var loadCycles uint64
+	if !fdc.headLoaded {
+		fdc.headLoaded = true
+		fdc.outputPort |= fdcOutputLoadHead
+		loadCycles = fdc.headLoadCycles
+	}
+	fdc.after(fdcCommandDelayCycle, func() {
+		fdc.seek(fdc.physicalTrack(fdc.track), loadCycles, next)
+	})
+}
+
+// The 8271 skips the bad tracks
+func (fdc *fdc8271) physicalTrack(track uint8) uint8 {
+	physical := track
+	for _, bad := range fdc.badTracks[fdc.drive] {
+		if bad != 0xff && physical >= bad {
+			physical++
+		}
+	}
+	return physical
+}
+
+// Steps the head one track at a time, then waits for the head to settle
+func (fdc *fdc8271) seek(target uint8, loadCycles uint64, next func()) {
+	d := &fdc.drives[fdc.drive]
+	current := fdc.currentTrack[fdc.drive]
+	if target == 0 && d.track != 0 {
+		current = 0xff // Steps out until the track 0 sensor
+	}
+
+	if current == target || (target == 0 && d.track == 0) {
+		fdc.currentTrack[fdc.drive] = target
+		delay := loadCycles
+		if fdc.settleCycles > delay {
+			delay = fdc.settleCycles
+		}
+		fdc.after(delay, next)
+		return
+	}
+
+	fdc.after(fdc.stepCycles, func() {
+		fdc.steps = append(fdc.steps, fdc.now())
+		if current < target {
+			fdc.currentTrack[fdc.drive] = current + 1
+			if d.track < diskTracks-1 {
+				d.track++
+			}
+		} else {
+			fdc.currentTrack[fdc.drive] = current - 1
+			if d.track > 0 {
+				d.track--
+			}
+		}
+		if current == 0xff {
+			fdc.currentTrack[fdc.drive] = d.track
+		}
+		fdc.seek(target, loadCycles, next)
+	})
+}
+
+// Cycles until the position in bytes from the index is under the head
+func (fdc *fdc8271) cyclesToPosition(position int) uint64 {
+	angle := fdc.now() % fdcRevolutionCycles
+	target := uint64(position*fdcByteCycles) % fdcRevolutionCycles
+	return (target + fdcRevolutionCycles - angle) % fdcRevolutionCycles
+}
+
+// Returns the next sector to pass under the head with an ID accepted by match
+func (fdc *fdc8271) nextID(match func(s *trackSector) bool) *trackSector {
+	d := &fdc.drives[fdc.drive]
+	t := d.disk.track(d.track)
+	if t == nil {
+		return nil
+	}
+
+	angle := int(fdc.now() % fdcRevolutionCycles / fdcByteCycles)
+	var first *trackSector
+	for i := range t.sectors {
+		s := &t.sectors[i]
+		if !match(s) {
+			continue
+		}
+		if s.idPos >= angle {
+			return s
+		}
+		if first == nil {
+			first = s // On the next revolution
+		}
+	}
+	return first
+}
+
+// Waits for the ID of the current sector, then runs next at the start of the data
+func (fdc *fdc8271) findSector(next func(s *trackSector)) {
+	s := fdc.nextID(func(s *trackSector) bool {
+		return s.id[0] == fdc.track && s.id[2] == fdc.sector
+	})
+	if s == nil || s.data == nil {
+		// The ID or the data are never found
+		fdc.searchIndexes = 0
+		fdc.waitNotFound()
+		return
+	}
+
+	fdc.after(fdc.cyclesToPosition(s.idPos), func() {
+		if !s.idCRCOK {
+			fdc.finish(fdcResultIDCRC)
+			return
+		}
+		fdc.after(uint64(s.dataPos+1-s.idPos)*fdcByteCycles, func() { next(s) })
+	})
+}
+
+func (fdc *fdc8271) waitNotFound() {
+	if fdc.searchIndexes >= fdcNotFoundIndexes {
+		fdc.finish(fdcResultSectorNotFound)
+		return
+	}
+	fdc.after(fdcByteCycles, fdc.waitNotFound)
+}
+
+// Asks the CPU to read or write the data register
+func (fdc *fdc8271) request() {
+	fdc.status |= fdcStatusNonDMA
+	fdc.interrupt()
+}
+
+// The CPU has not served the previous request
+func (fdc *fdc8271) isLate() bool {
+	if fdc.status&fdcStatusNonDMA != 0 {
+		fdc.logf("Late data on byte %v\n", fdc.transferIndex)
+		fdc.finish(fdcResultLateDMA)
+		return true
+	}
+	return false
+}
+
+// The weak bits read differently each time, the sequence is fixed to be repeatable
+func (fdc *fdc8271) random() uint8 {
+	// Xorshift
+	fdc.randomState ^= fdc.randomState << 13
+	fdc.randomState ^= fdc.randomState >> 17
+	fdc.randomState ^= fdc.randomState << 5
+	return uint8(fdc.randomState)
+}
+
+// Moves the bytes of the current sector as set by transfer
+func (fdc *fdc8271) transferSector() {
+	if fdc.sectorCount == 0 {
+		fdc.finish(fdcResultOK)
+		return
+	}
+
+	fdc.findSector(func(s *trackSector) {
+		if s.deleted {
+			if !fdc.acceptDeleted {
+				fdc.finish(fdcResultDeletedData)
+				return
+			}
+			fdc.deletedFound = true
+		}
+
+		fdc.transferIndex = 0
+		fdc.scanMatch = true
+		if fdc.transfer != transferRead && fdc.requestBytes > 0 {
+			// The first byte is needed before it is written or compared
+			fdc.request()
+		}
+		fdc.after(fdcByteCycles, func() { fdc.transferByte(s) })
+	})
+}
+
+func (fdc *fdc8271) transferByte(s *trackSector) {
+	if fdc.isLate() {
+		return
+	}
+
+	index := fdc.transferIndex
+	inSector := index < len(s.data)
+	var value uint8
+	if inSector {
+		value = s.data[index]
+		if s.weak != nil && s.weak[index] {
+			value = fdc.random()
+		}
+	}
+
+	d := &fdc.drives[fdc.drive]
+	if fdc.transfer == transferWrite {
+		d.writing = true
+	} else {
+		d.reading = true
+	}
+	switch fdc.transfer {
+	case transferRead:
+		fdc.data = value
+		fdc.request()
+	case transferWrite:
+		if inSector {
+			s.data[index] = fdc.data
+			d.dirty = true
+		}
+	case transferScan:
+		// The key bytes with 0xff match anything
+		if index < fdc.requestBytes && fdc.data != 0xff && fdc.data != value {
+			fdc.scanMatch = false
+		}
+	}
+	fdc.transferIndex++
+
+	if fdc.transfer != transferRead && fdc.transferIndex < fdc.requestBytes {
+		fdc.request()
+	}
+	if fdc.transferIndex < fdc.sectorSize {
+		fdc.after(fdcByteCycles, func() { fdc.transferByte(s) })
+		return
+	}
+
+	// Skip the CRC and go for the next sector
+	fdc.after(layoutCRCSize*fdcByteCycles, func() { fdc.nextSector(s) })
+}
+
+func (fdc *fdc8271) nextSector(s *trackSector) {
+	if fdc.transfer == transferWrite {
+		if fdc.command&0x3f == 0x0f && !s.deleted {
+			fdc.logf("The deleted data mark can't be stored on the image\n")
+		}
+	} else if fdc.sectorSize != len(s.data) || !s.dataCRCOK {
+		// The CRC is not after the bytes read or is wrong
+		fdc.finish(fdcResultDataCRC)
+		return
+	}
+
+	fdc.sectorCount--
+	if fdc.transfer == transferScan {
+		if fdc.scanMatch {
+			fdc.scanSector = fdc.sector
+			fdc.finish(fdcResultOK)
+			return
+		}
+		if fdc.sectorCount == 0 {
+			fdc.finish(fdcResultScanNotMet)
+			return
+		}
+	}
+	fdc.sector += fdc.sectorStep
+	fdc.transferSector()
+}
+
+// Returns the bytes C, H, R and N of the IDs as they pass under the head
+func (fdc *fdc8271) readIDs() {
+	if fdc.sectorCount == 0 {
+		fdc.finish(fdcResultOK)
+		return
+	}
+
+	s := fdc.nextID(func(*trackSector) bool { return true })
+	if s == nil {
+		// No IDs on the track
+		fdc.searchIndexes = 0
+		fdc.waitNotFound()
+		return
+	}
+
+	// The ID mark is skipped
+	fdc.after(fdc.cyclesToPosition(s.idPos+1), func() {
+		fdc.transferIndex = 0
+		fdc.idByte(s)
+	})
+}
+
+func (fdc *fdc8271) idByte(s *trackSector) {
+	if fdc.isLate() {
+		return
+	}
+
+	fdc.data = s.id[fdc.transferIndex]
+	fdc.drives[fdc.drive].reading = true
+	fdc.request()
+	fdc.transferIndex++
+	if fdc.transferIndex < len(s.id) {
+		fdc.after(fdcByteCycles, func() { fdc.idByte(s) })
+		return
+	}
+
+	// Skip the CRC and go for the next ID
+	fdc.after((layoutCRCSize+1)*fdcByteCycles, func() {
+		if !s.idCRCOK {
+			fdc.finish(fdcResultIDCRC)
+			return
+		}
+		fdc.sectorCount--
+		fdc.readIDs()
+	})
+}
+
+// Formats the track with the IDs given by the CPU, four bytes per sector
+func (fdc *fdc8271) formatTrack() {
+	fdc.after(fdc.cyclesToPosition(0), func() {
+		fdc.after(uint64(fdc.formatGap1+layoutSync)*fdcByteCycles, fdc.formatSector)
+	})
+}
+
+func (fdc *fdc8271) formatSector() {
+	fdc.transferIndex = 0
+	fdc.request()
+	fdc.after(fdcByteCycles, fdc.formatIDByte)
+}
+
+func (fdc *fdc8271) formatIDByte() {
+	if fdc.isLate() {
+		return
+	}
+
+	fdc.formatID[fdc.transferIndex] = fdc.data
+	fdc.drives[fdc.drive].writing = true
+	fdc.transferIndex++
+	if fdc.transferIndex < len(fdc.formatID) {
+		fdc.request()
+		fdc.after(fdcByteCycles, fdc.formatIDByte)
+		return
+	}
+
+	d := &fdc.drives[fdc.drive]
+	if !d.disk.formatSector(d.track, fdc.formatID) {
+		fdc.logf("The ID %v can't be stored on the image\n", fdc.formatID)
+	}
+	d.dirty = true
+
+	fdc.sectorCount--
+	if fdc.sectorCount == 0 {
+		// Done on the next index
+		fdc.after(fdc.cyclesToPosition(0), func() { fdc.finish(fdcResultOK) })
+		return
+	}
+
+	// The CRC, gap 2, the data and gap 3
+	rest := layoutCRCSize + layoutIDToData - layoutIDSize + fdc.sectorSize + layoutCRCSize +
+		fdc.formatGap3 + layoutSync
+	fdc.after(uint64(rest)*fdcByteCycles, fdc.formatSector)
+}
+
+func (fdc *fdc8271) finish(result uint8) {
+	if fdc.deletedFound {
+		result |= fdcResultDeletedData
+	}
+	fdc.logf("Result 0x%02x\n", result)
+	fdc.result = result
+	fdc.status = fdcStatusResultFull
+	fdc.idleIndexes = 0
+	fdc.interrupt()
 }
 
 func (fdc *fdc8271) read(port uint8) uint8 {
 	switch port {
 	case 0:
 		//fdc.logf("Status: 0x%02x\n", fdc.status)
-		return fdc.status
 	case 1:
 		fdc.logf("Result: 0x%02x\n", fdc.result)
-		return fdc.result
+		value := fdc.result
+		fdc.status &^= fdcStatusResultFull | fdcStatusInterrupt
+		return value
 	case 2:
 		fdc.logf("Reset Read (Illegal)\n")
 	case 3:
 		fdc.logf("Do not use\n")
 	default:
-		if (fdc.status & 0x10) != 0 /* Result full */ {
-			fdc.status = 0x00
-		} else {
-			fdc.status = 0x80 /* busy */
-			fdc.raiseNMIDelayed()
-		}
-
 		//fdc.logf("Read data at %v\n", port)
-		return fdc.nextByte
+		fdc.activityCycle = fdc.cycle()
+		value := fdc.data
+		fdc.status &^= fdcStatusNonDMA | fdcStatusInterrupt
+		return value
+	}
+	return fdc.peek(port)
+}
+
+// Returns the value of a port as read would, without side effects
+func (fdc *fdc8271) peek(port uint8) uint8 {
+	switch port {
+	case 0:
+		return fdc.status
+	case 1:
+		return fdc.result
+	case 2, 3:
+		return 0
+	default:
+		return fdc.data
 	}
-	return 0
 }
 
-func (fdc *fdc8271) loadDisk(name string) {
-	data, err := os.ReadFile(name)
+// Inserts a disk, the changes of the disk replaced are saved first
+func (fdc *fdc8271) loadDisk(drive int, name string, disk floppyDisk) error {
+	d := &fdc.drives[drive]
+	err := d.flush()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	d.disk = disk
+	d.path = name
+	d.dirty = false
+	d.writeProtect = false
+	d.overlay = false
+	d.original = nil
+	d.inMemory = false
+	return nil
+}
+
+func (fdc *fdc8271) flush() error {
+	for i := range fdc.drives {
+		err := fdc.drives[i].flush()
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	fdc.data = data
+// Saves the changes on the image, or on the sidecar with the overlay
+func (d *floppyDrive) flush() error {
+	if !d.dirty || d.inMemory {
+		return nil
+	}
+	var err error
+	if d.overlay {
+		err = d.saveOverlay()
+	} else {
+		err = os.WriteFile(d.path, d.disk.image(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
 }
 
 /*
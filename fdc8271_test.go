@@ -0,0 +1,210 @@
+package izatom
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixture has the title FIXTURE, a BASIC program HELLO and DATA
+func bootDOS(t *testing.T) *Atom {
+	t.Helper()
+	a := bootAtom(t)
+	err := a.LoadDiskWithOptions(filepath.Join("testdata", "dos.40t"), DiskOptions{WriteProtect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeLines(t, a, "*DOS")
+	return a
+}
+
+func TestDOSCatalogue(t *testing.T) {
+	a := bootDOS(t)
+	typeLines(t, a, "*CAT")
+	output := commandOutput(t, a, "*CAT")
+	if len(output) != 2 {
+		t.Fatalf("*CAT printed %q", output)
+	}
+	if !strings.HasPrefix(output[0], "FIXTURE") || !strings.Contains(output[0], "DRIVE 0") {
+		t.Errorf("the title line is %q", output[0])
+	}
+	// Sorted, with the directory
+	files := strings.Fields(output[1])
+	if len(files) != 3 || files[0] != ":" || files[1] != "DATA" || files[2] != "HELLO" {
+		t.Errorf("the files are %q", output[1])
+	}
+}
+
+func TestDOSLoadAndRun(t *testing.T) {
+	a := bootDOS(t)
+	typeLines(t, a, "LOAD\"HELLO\"", "RUN")
+	output := commandOutput(t, a, "RUN")
+	if len(output) != 1 || output[0] != "HELLO" {
+		t.Errorf("RUN printed %q", output)
+	}
+}
+
+// Returns the path of a new blank image of a track
+func blankImage(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blank.40t")
+	err := os.WriteFile(path, make([]uint8, 10*256), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns a new Atom with the image on the drive
+func loadAtom(t *testing.T, path string) *Atom {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.LoadDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Changes a byte of the disk on drive 0, as a write by the FDC
+func writeDisk(a *Atom, offset int, value uint8) {
+	d := &a.fdc.drives[0]
+	d.disk.image()[offset] = value
+	d.dirty = true
+}
+
+// Runs until the end of the first frame
+func runCancelled(a *Atom) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return a.Run(ctx)
+}
+
+func TestRunSavesTheDisks(t *testing.T) {
+	path := blankImage(t)
+	a := loadAtom(t, path)
+	writeDisk(a, 0x100, 0x5a)
+
+	err := runCancelled(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0x100] != 0x5a {
+		t.Error("the change is not saved on the image")
+	}
+}
+
+func TestRunReturnsTheSaveErrors(t *testing.T) {
+	path := blankImage(t)
+	a := loadAtom(t, path)
+	writeDisk(a, 0x100, 0x5a)
+
+	os.RemoveAll(filepath.Dir(path)) // The image can't be written back
+	err := runCancelled(a)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Run returns %v, not the error saving the disk", err)
+	}
+}
+
+func TestLoadDiskSavesTheReplaced(t *testing.T) {
+	first := blankImage(t)
+	a := loadAtom(t, first)
+	runFrames(t, a, 1)
+	writeDisk(a, 0x100, 0x5a)
+
+	err := a.LoadDisk(blankImage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0x100] != 0x5a {
+		t.Error("the change is not saved on the image replaced")
+	}
+}
+
+func TestLoadDiskReturnsTheSaveErrors(t *testing.T) {
+	first := blankImage(t)
+	a := loadAtom(t, first)
+	runFrames(t, a, 1)
+	writeDisk(a, 0x100, 0x5a)
+
+	os.RemoveAll(filepath.Dir(first)) // The image can't be written back
+	err := a.LoadDisk(blankImage(t))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadDisk returns %v, not the error saving the disk", err)
+	}
+	if d := a.fdc.drives[0]; d.path != first || !d.dirty {
+		t.Error("the disk with the changes not saved is replaced")
+	}
+}
+
+// Runs a command on the FDC until it is done and returns the result
+func runCommand(a *Atom, command uint8, params ...uint8) uint8 {
+	a.fdc.write(0, command)
+	for _, p := range params {
+		a.fdc.write(1, p)
+	}
+	for a.fdc.status&fdcStatusBusy != 0 {
+		a.step()
+	}
+	return a.fdc.read(1)
+}
+
+func TestSeekKeepsTheTrackOfEachDrive(t *testing.T) {
+	a := loadAtom(t, blankImage(t))
+	err := a.LoadDiskWithOptions(blankImage(t), DiskOptions{Drive: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JMP #2800, and PLA, RTI through the NMI vector of the ROM
+	for i, b := range []uint8{0x4c, 0x00, 0x28, 0, 0x68, 0x40} {
+		a.Poke(0x2800+uint16(i), b)
+	}
+	a.Poke(0x0200, 0x04)
+	a.Poke(0x0201, 0x28)
+	a.cpu.SetPC(0x2800)
+
+	seek := func(drive int, track uint8) {
+		t.Helper()
+		selected := uint8(fdcOutputSelect0 << drive)
+		runCommand(a, selected|0x3a, 0x23, selected) // Starts the motor
+		for !a.fdc.isReady(drive) {
+			a.step()
+		}
+		result := runCommand(a, selected|0x29, track)
+		if result != fdcResultOK {
+			t.Fatalf("the seek on drive %v returns 0x%02x", drive, result)
+		}
+	}
+
+	// Track 1 is bad on the surface of drive 1 only
+	runCommand(a, 0x35, 0x18, 0x01, 0xff, 0x00)
+
+	seek(0, 5)
+	seek(1, 2)
+	seek(0, 6)
+	if track := a.fdc.drives[0].track; track != 6 {
+		t.Errorf("drive 0 is on track %v, not 6", track)
+	}
+	if track := a.fdc.drives[1].track; track != 3 {
+		t.Errorf("drive 1 is on track %v, not 3", track)
+	}
+	if a.fdc.currentTrack != [2]uint8{6, 3} {
+		t.Errorf("the current tracks are %v, not [6 3]", a.fdc.currentTrack)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"image"
+	"unsafe"
+
+	"github.com/ivanizag/izatom"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+The audio of the emulated frames, the speaker and the drive sounds, is
+queued on an SDL audio device from the frame listener. While the
+emulation runs faster than real time the samples that don't fit on the
+queue are dropped, to keep the latency low.
+*/
+
+const audioMaxQueued = izatom.AudioSampleRate / 5 * 2 // 200ms of 16 bit samples, in bytes
+
+type audioOutput struct {
+	device sdl.AudioDeviceID
+}
+
+func newAudioOutput() (*audioOutput, error) {
+	err := sdl.InitSubSystem(sdl.INIT_AUDIO)
+	if err != nil {
+		return nil, err
+	}
+	spec := sdl.AudioSpec{
+		Freq:     izatom.AudioSampleRate,
+		Format:   sdl.AUDIO_S16SYS,
+		Channels: 1,
+		Samples:  1024,
+	}
+	device, err := sdl.OpenAudioDevice("", false, &spec, nil, 0)
+	if err != nil {
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return nil, err
+	}
+	sdl.PauseAudioDevice(device, false)
+	return &audioOutput{device}, nil
+}
+
+// The frame listener. SDL copies the samples, the slice can be reused.
+func (o *audioOutput) queue(img *image.RGBA, audio []int16) {
+	if len(audio) == 0 || sdl.GetQueuedAudioSize(o.device) > audioMaxQueued {
+		return
+	}
+	data := unsafe.Slice((*uint8)(unsafe.Pointer(&audio[0])), len(audio)*2)
+	sdl.QueueAudio(o.device, data)
+}
+
+func (o *audioOutput) close() {
+	sdl.CloseAudioDevice(o.device)
+	sdl.QuitSubSystem(sdl.INIT_AUDIO)
+}
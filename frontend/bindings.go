@@ -0,0 +1,163 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ivanizag/izatom"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+Mapping of the host keys to Atom keys and to frontend actions. It is
+loaded from a file, see default_keys.conf for the format. The default
+maps the keys by their position on a UK keyboard.
+*/
+
+//go:embed default_keys.conf
+var defaultBindings string
+
+var actionNames = map[string]bool{
+	"reset":      true,
+	"screenshot": true,
+	"record":     true,
+	"filter":     true,
+	"pause":      true,
+	"turbo":      true,
+	"faster":     true,
+	"slower":     true,
+	"commit":     true,
+	"discard":    true,
+}
+
+const (
+	modCtrl  = uint16(sdl.KMOD_CTRL)
+	modShift = uint16(sdl.KMOD_SHIFT)
+	modAlt   = uint16(sdl.KMOD_ALT)
+)
+
+type binding struct {
+	scancode  sdl.Scancode
+	modifiers uint16 // Left and right are the same: modCtrl, modShift and modAlt
+}
+
+type target struct {
+	key    int // izatom.KEY_NONE for actions
+	action string
+}
+
+type keyBindings struct {
+	targets map[binding]target
+	pressed map[sdl.Scancode]target
+}
+
+func loadBindings(path string) (*keyBindings, error) {
+	if path == "" {
+		return parseBindings(defaultBindings)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBindings(string(data))
+}
+
+func parseBindings(text string) (*keyBindings, error) {
+	kb := keyBindings{
+		targets: make(map[binding]target),
+		pressed: make(map[sdl.Scancode]target),
+	}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		b, t, err := parseBinding(line)
+		if err != nil {
+			return nil, fmt.Errorf("key bindings line %v: %v", i+1, err)
+		}
+		kb.targets[b] = t
+	}
+	return &kb, nil
+}
+
+func parseBinding(line string) (binding, target, error) {
+	var b binding
+	var t target
+
+	separator := strings.Index(line[1:], " = ") + 1 // The host key can be "="
+	if separator == 0 {
+		return b, t, fmt.Errorf("expected 'host key = target'")
+	}
+	host := strings.TrimSpace(line[:separator])
+	name := line[separator+3:]
+	if comment := strings.Index(name, "#"); comment >= 0 {
+		name = name[:comment]
+	}
+	name = strings.TrimSpace(name)
+
+	modifiers := map[string]uint16{
+		"ctrl+":  modCtrl,
+		"shift+": modShift,
+		"alt+":   modAlt,
+	}
+	for found := true; found; {
+		found = false
+		for prefix, modifier := range modifiers {
+			if len(host) > len(prefix) && strings.EqualFold(host[:len(prefix)], prefix) {
+				b.modifiers |= modifier
+				host = host[len(prefix):]
+				found = true
+			}
+		}
+	}
+	b.scancode = sdl.GetScancodeFromName(host)
+	if b.scancode == sdl.SCANCODE_UNKNOWN {
+		return b, t, fmt.Errorf("unknown host key '%v'", host)
+	}
+
+	if actionNames[name] {
+		t.key = izatom.KEY_NONE
+		t.action = name
+	} else {
+		key, ok := izatom.KeyByName(name)
+		if !ok {
+			return b, t, fmt.Errorf("unknown Atom key or action '%v'", name)
+		}
+		t.key = key
+	}
+	return b, t, nil
+}
+
+// Returns the target of a key event. Releases go to the target of the press.
+func (kb *keyBindings) event(e *sdl.KeyboardEvent) (target, bool) {
+	scancode := e.Keysym.Scancode
+	if e.State == sdl.RELEASED {
+		t, ok := kb.pressed[scancode]
+		delete(kb.pressed, scancode)
+		return t, ok
+	}
+
+	t, ok := kb.targets[binding{scancode, normalizeModifiers(e.Keysym.Mod)}]
+	if !ok {
+		// Keys without modifiers, the modifiers can be Atom keys too
+		t, ok = kb.targets[binding{scancode, 0}]
+	}
+	if ok {
+		kb.pressed[scancode] = t
+	}
+	return t, ok
+}
+
+// Left and right modifiers are the same for the bindings
+func normalizeModifiers(modifiers uint16) uint16 {
+	var normalized uint16
+	for _, modifier := range []uint16{modCtrl, modShift, modAlt} {
+		if modifiers&modifier != 0 {
+			normalized |= modifier
+		}
+	}
+	return normalized
+}
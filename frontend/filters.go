@@ -0,0 +1,189 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+Post processing filters applied to the Atom snapshot before it is
+sent to SDL. They are cycled at runtime with a hotkey.
+*/
+
+type filter struct {
+	name         string
+	apply        func(img *image.RGBA) *image.RGBA
+	pixelPerfect bool // Integer scaling instead of stretching to the window
+	onlyRG       bool // Applied only on the graphic modes of 1 bit per pixel
+}
+
+var filters = []filter{
+	{"none", nil, false, false},
+	{"scanlines", filterScanlines, false, false},
+	{"composite", filterComposite, false, true},
+	{"pixel perfect", nil, true, false},
+	{"soft", filterBlur, false, false},
+}
+
+// Returns true if the filter has to be applied on the screen of the video mode
+func (f *filter) appliesTo(isGraphic bool, graphicMode uint8) bool {
+	if f.apply == nil {
+		return false
+	}
+	// The RG modes are the odd ones, the CG modes have 2 bits per pixel
+	return !f.onlyRG || (isGraphic && graphicMode%2 == 1)
+}
+
+// Dark every other line, the output has double the lines.
+func filterScanlines(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()*2))
+	for y := 0; y < b.Dy(); y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+b.Dx()*4]
+		bright := out.Pix[2*y*out.Stride : 2*y*out.Stride+b.Dx()*4]
+		dark := out.Pix[(2*y+1)*out.Stride : (2*y+1)*out.Stride+b.Dx()*4]
+		copy(bright, src)
+		for i := 0; i < len(src); i += 4 {
+			dark[i] = src[i] / 2
+			dark[i+1] = src[i+1] / 2
+			dark[i+2] = src[i+2] / 2
+			dark[i+3] = 0xff
+		}
+	}
+	return out
+}
+
+/*
+On a composite monitor two adjacent pixels of the 256x192 mode can't
+be resolved and the luma transitions are decoded as color. A dark-light
+pair shows as blue and a light-dark pair as orange, the pixels on both
+sides of a transition are mixed with its color. Some Atom software
+used this to get colors on the two color mode. The alphanumeric and the
+color modes don't have the artifacts, the filter is only for the RG
+modes.
+*/
+var artifactColors = [2]color.RGBA{
+	{0xff, 0x7f, 0x1f, 0xff}, // Orange
+	{0x1f, 0x7f, 0xff, 0xff}, // Blue
+}
+
+func filterComposite(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	copy(out.Pix, img.Pix)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[y*img.Stride:]
+		pixel := func(x int) []uint8 {
+			return row[x*4 : x*4+4]
+		}
+		for x := 0; x < b.Dx(); x++ {
+			// The transitions with the pixels on both sides, on any column
+			var mixed [3]int
+			transitions := 0
+			for _, left := range []int{x - 1, x} {
+				if left < 0 || left+1 >= b.Dx() {
+					continue
+				}
+				l, r := pixel(left), pixel(left+1)
+				c, ok := artifactColor(l, r)
+				if !ok {
+					continue
+				}
+				artifact := [3]uint8{c.R, c.G, c.B}
+				for i := 0; i < 3; i++ {
+					average := (int(l[i]) + int(r[i])) / 2
+					mixed[i] += (average + int(artifact[i])) / 2
+				}
+				transitions++
+			}
+			if transitions == 0 {
+				continue
+			}
+			dst := out.Pix[y*out.Stride+x*4:]
+			for i := 0; i < 3; i++ {
+				dst[i] = uint8(mixed[i] / transitions)
+			}
+		}
+	}
+	return out
+}
+
+// Returns the color of the transition between two pixels, false if there is none
+func artifactColor(left []uint8, right []uint8) (color.RGBA, bool) {
+	lumaLeft := luma(left)
+	lumaRight := luma(right)
+	if lumaLeft-lumaRight < 0x20 && lumaRight-lumaLeft < 0x20 {
+		return color.RGBA{}, false
+	}
+	if lumaLeft > lumaRight {
+		return artifactColors[0], true
+	}
+	return artifactColors[1], true
+}
+
+func luma(pixel []uint8) int {
+	return (299*int(pixel[0]) + 587*int(pixel[1]) + 114*int(pixel[2])) / 1000
+}
+
+// Separable [1 2 1] blur
+func filterBlur(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	horizontal := image.NewRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			blurPixel(horizontal, img, x, y, x-1, y, x+1, y)
+		}
+	}
+	out := image.NewRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			blurPixel(out, horizontal, x, y, x, y-1, x, y+1)
+		}
+	}
+	return out
+}
+
+func blurPixel(dst *image.RGBA, src *image.RGBA, x, y, x0, y0, x1, y1 int) {
+	b := src.Bounds()
+	if !(image.Point{x0, y0}.In(b)) {
+		x0, y0 = x, y
+	}
+	if !(image.Point{x1, y1}.In(b)) {
+		x1, y1 = x, y
+	}
+	center := src.PixOffset(x, y)
+	before := src.PixOffset(x0, y0)
+	after := src.PixOffset(x1, y1)
+	for i := 0; i < 3; i++ {
+		value := int(src.Pix[before+i]) + 2*int(src.Pix[center+i]) + int(src.Pix[after+i])
+		dst.Pix[center+i] = uint8(value / 4)
+	}
+	dst.Pix[center+3] = 0xff
+}
+
+/*
+The Atom screen, with or without border, was shown with a 4:3 aspect
+ratio. The largest integer horizontal factor that fits is chosen and the
+vertical factor is rounded to keep the aspect.
+*/
+func pixelPerfectRect(width, height int, windowWidth, windowHeight int32) *sdl.Rect {
+	for scaleX := int(windowWidth) / width; scaleX > 0; scaleX-- {
+		scaleY := (scaleX*width*3/4 + height/2) / height
+		if scaleY < 1 {
+			scaleY = 1
+		}
+		w := int32(width * scaleX)
+		h := int32(height * scaleY)
+		if h <= windowHeight {
+			return &sdl.Rect{
+				X: (windowWidth - w) / 2,
+				Y: (windowHeight - h) / 2,
+				W: w,
+				H: h,
+			}
+		}
+	}
+	return nil // Too small, stretch
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ivanizag/izatom"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+SDL game controllers are mapped to the Atom joystick. The D-pad and the
+left stick are the directions, the A and B buttons are fire.
+*/
+
+const axisDeadZone = 8000
+
+type gamepad struct {
+	a         *izatom.Atom
+	isPressed [izatom.JOYSTICK_SIZE]bool
+}
+
+func newGamepad(a *izatom.Atom) (*gamepad, error) {
+	err := sdl.InitSubSystem(sdl.INIT_GAMECONTROLLER)
+	if err != nil {
+		return nil, err
+	}
+	return &gamepad{a: a}, nil
+}
+
+// Parses the joystick keys in the order up,down,left,right,fire
+func parseJoystickKeys(s string) ([izatom.JOYSTICK_SIZE]int, error) {
+	var keys [izatom.JOYSTICK_SIZE]int
+	names := strings.Split(s, ",")
+	if len(names) != izatom.JOYSTICK_SIZE {
+		return keys, fmt.Errorf("%v keys expected for the joystick: up,down,left,right,fire", izatom.JOYSTICK_SIZE)
+	}
+	for i, name := range names {
+		key, ok := izatom.KeyByName(strings.TrimSpace(name))
+		if !ok {
+			return keys, fmt.Errorf("unknown key '%v'", name)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+func (g *gamepad) set(button int, pressed bool) {
+	if g.isPressed[button] != pressed {
+		g.isPressed[button] = pressed
+		g.a.SendJoystick(button, !pressed)
+	}
+}
+
+func (g *gamepad) event(event sdl.Event) {
+	switch e := event.(type) {
+	case *sdl.ControllerDeviceEvent:
+		if e.Type == sdl.CONTROLLERDEVICEADDED {
+			sdl.GameControllerOpen(int(e.Which))
+		}
+	case *sdl.ControllerButtonEvent:
+		pressed := e.State == sdl.PRESSED
+		switch e.Button {
+		case sdl.CONTROLLER_BUTTON_DPAD_UP:
+			g.set(izatom.JOYSTICK_UP, pressed)
+		case sdl.CONTROLLER_BUTTON_DPAD_DOWN:
+			g.set(izatom.JOYSTICK_DOWN, pressed)
+		case sdl.CONTROLLER_BUTTON_DPAD_LEFT:
+			g.set(izatom.JOYSTICK_LEFT, pressed)
+		case sdl.CONTROLLER_BUTTON_DPAD_RIGHT:
+			g.set(izatom.JOYSTICK_RIGHT, pressed)
+		case sdl.CONTROLLER_BUTTON_A, sdl.CONTROLLER_BUTTON_B:
+			g.set(izatom.JOYSTICK_FIRE, pressed)
+		}
+	case *sdl.ControllerAxisEvent:
+		switch e.Axis {
+		case sdl.CONTROLLER_AXIS_LEFTX:
+			g.set(izatom.JOYSTICK_LEFT, e.Value < -axisDeadZone)
+			g.set(izatom.JOYSTICK_RIGHT, e.Value > axisDeadZone)
+		case sdl.CONTROLLER_AXIS_LEFTY:
+			g.set(izatom.JOYSTICK_UP, e.Value < -axisDeadZone)
+			g.set(izatom.JOYSTICK_DOWN, e.Value > axisDeadZone)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/ivanizag/izatom"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+Logical keyboard mapping. The character keys are not mapped by position,
+the text typed on the host is converted to the Atom key that produces
+the same character, with or without SHIFT. This works with any host
+layout. The SHIFT state of the host is overridden while the key is held.
+
+Keys with no character, like RETURN or the cursor keys, and any key with
+CTRL pressed are still mapped by position.
+*/
+
+const (
+	logicalHoldTicks    = 2 // Frontend loop iterations with the key pressed
+	logicalReleaseTicks = 2 // Frontend loop iterations before the next key
+)
+
+var nonCharacterKeys = map[int]bool{
+	izatom.KEY_ESC:        true,
+	izatom.KEY_UP:         true,
+	izatom.KEY_BREAK:      true,
+	izatom.KEY_LEFT_RIGHT: true,
+	izatom.KEY_COPY:       true,
+	izatom.KEY_DELETE:     true,
+	izatom.KEY_UP_DOWN:    true,
+	izatom.KEY_CTRL:       true,
+	izatom.KEY_RETURN:     true,
+	izatom.KEY_LOCK:       true,
+	izatom.KEY_LSHIFT:     true,
+	izatom.KEY_RSHIFT:     true,
+	izatom.KEY_REPT:       true,
+}
+
+type logicalStroke struct {
+	key   int
+	shift bool
+}
+
+type logicalKeyboard struct {
+	a          *izatom.Atom
+	hostLShift bool
+	hostRShift bool
+	hostCtrl   bool
+
+	pending []logicalStroke
+	ticks   int  // Ticks left on the current step
+	holding bool // The first pending stroke is pressed
+}
+
+func newLogicalKeyboard(a *izatom.Atom) *logicalKeyboard {
+	sdl.StartTextInput()
+	return &logicalKeyboard{a: a}
+}
+
+func (k *logicalKeyboard) sendKey(atomkey int, released bool) {
+	switch atomkey {
+	case izatom.KEY_LSHIFT:
+		k.hostLShift = !released
+		if k.holding {
+			return // Sent when the override ends
+		}
+	case izatom.KEY_RSHIFT:
+		k.hostRShift = !released
+		if k.holding {
+			return // Sent when the override ends
+		}
+	case izatom.KEY_CTRL:
+		k.hostCtrl = !released
+	}
+
+	if nonCharacterKeys[atomkey] || k.hostCtrl || released {
+		// Releases are always sent, to not leave keys pressed when CTRL changes
+		k.a.SendKey(atomkey, released)
+	}
+}
+
+func (k *logicalKeyboard) sendText(e *sdl.TextInputEvent) {
+	if k.hostCtrl {
+		return // Already sent by position
+	}
+	for _, ch := range e.GetText() {
+		key, shift, ok := izatom.KeyForChar(ch)
+		if ok {
+			k.pending = append(k.pending, logicalStroke{key, shift})
+		}
+	}
+}
+
+// To be called once per frontend loop iteration
+func (k *logicalKeyboard) tick() {
+	if k.ticks > 0 {
+		k.ticks--
+		return
+	}
+	if len(k.pending) == 0 {
+		return
+	}
+
+	stroke := k.pending[0]
+	if !k.holding {
+		k.a.SendKey(izatom.KEY_LSHIFT, !stroke.shift)
+		k.a.SendKey(izatom.KEY_RSHIFT, !stroke.shift)
+		k.a.SendKey(stroke.key, false)
+		k.holding = true
+		k.ticks = logicalHoldTicks
+	} else {
+		k.a.SendKey(stroke.key, true)
+		k.a.SendKey(izatom.KEY_LSHIFT, !k.hostLShift)
+		k.a.SendKey(izatom.KEY_RSHIFT, !k.hostRShift)
+		k.holding = false
+		k.pending = k.pending[1:]
+		k.ticks = logicalReleaseTicks
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
 	"os"
 	"unsafe"
 
@@ -8,21 +12,105 @@ import (
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+const (
+	minSpeed = 1.0 / 16
+	maxSpeed = 16
+)
+
 func main() {
+	pal := flag.Bool("pal", false, "use the PAL timing of the European Atom")
+	border := flag.Bool("border", false, "show the border around the screen")
+	charRom := flag.String("charrom", "", "external character generator ROM with 8x12 cells")
+	charRomAlways := flag.Bool("charrom-always", false, "use the external characters always, not only with D6 set")
+	t1 := flag.Bool("t1", false, "use the MC6847T1 internal font with lower case")
+	keyboardMode := flag.String("keyboard", "positional", "keyboard mapping: positional or logical, to type the characters of a non UK host layout")
+	joystickMode := flag.String("joystick", "none", "joystick connection: none, keys or via")
+	joystickKeys := flag.String("joystick-keys", "COLON_ASTERISK,SLASH_QUESTION,Z,X,SPACE", "Atom keys for the joystick on keys mode: up,down,left,right,fire")
+	autoWarp := flag.Bool("autowarp", true, "run at full speed while the disk is busy")
+	keysFile := flag.String("keys", "", "key bindings file, the default is like default_keys.conf")
+	recordFormat := flag.String("record", "gif", "format of the recordings: gif or y4m (with a wav file for audio)")
+	protect := flag.Bool("protect", false, "write protect the disks")
+	overlay := flag.Bool("overlay", false, "save the disk changes on a sidecar .overlay file, not on the image")
+	driveLeds := flag.Bool("drive-leds", true, "show the LED and the track of the drives with the motor on")
+	driveSounds := flag.Bool("drive-sounds", false, "add the seek and motor sounds of the drives to the audio")
+	movieRecord := flag.String("movie-record", "", "record the inputs of the session on a movie file")
+	moviePlay := flag.String("movie-play", "", "replay the inputs of a movie file")
+	flag.Parse()
+
 	// Create a new atom
-	a := izatom.NewAtom()
-	if len(os.Args) > 1 {
-		a.LoadDisk(os.Args[1])
+	a, err := izatom.NewAtom()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var messages osd
+	if *pal {
+		a.SetVideoStandard(izatom.VideoPAL)
+	}
+	a.SetBorder(*border)
+	a.SetAutoWarp(*autoWarp)
+	a.SetDriveSounds(*driveSounds)
+	if *t1 {
+		a.SetFontVariant(izatom.FontMC6847T1)
+	}
+	if *charRom != "" {
+		mode := izatom.IntExtBit6
+		if *charRomAlways {
+			mode = izatom.IntExtExternal
+		}
+		err := a.LoadCharacterROM(*charRom, mode)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error loading the character ROM, using the internal font: %v", err))
+		}
+	}
+	switch *joystickMode {
+	case "keys":
+		keys, err := parseJoystickKeys(*joystickKeys)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error on the joystick keys, using the defaults: %v", err))
+		} else {
+			a.SetJoystickKeys(keys)
+		}
+		a.SetJoystickMode(izatom.JoystickKeys)
+	case "via":
+		a.SetJoystickMode(izatom.JoystickVIA)
+	}
+	for drive := 0; drive < flag.NArg() && drive < 2; drive++ {
+		err := a.LoadDiskWithOptions(flag.Arg(drive), izatom.DiskOptions{
+			Drive:        drive,
+			WriteProtect: *protect,
+			Overlay:      *overlay,
+		})
+		if err != nil {
+			messages.show(fmt.Sprintf("Error loading the disk: %v", err))
+		}
+	}
+	if *moviePlay != "" {
+		err := a.PlayMovie(*moviePlay)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error playing the movie: %v", err))
+		}
+	} else if *movieRecord != "" {
+		err := a.RecordMovie(*movieRecord, izatom.DefaultChecksumFrames)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error recording the movie: %v", err))
+		}
 	}
 
 	// Run the atom
-	go a.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- a.Run(ctx)
+	}()
 
 	// Prepare SDL
-	window, renderer, err := sdl.CreateWindowAndRenderer(256*4, 192*4,
+	size := a.Snapshot().Bounds()
+	window, renderer, err := sdl.CreateWindowAndRenderer(int32(size.Dx()*4), int32(size.Dy()*4),
 		sdl.WINDOW_SHOWN)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Error creating the window: %v\n", err)
+		os.Exit(1)
 	}
 	defer window.Destroy()
 	defer renderer.Destroy()
@@ -30,40 +118,218 @@ func main() {
 	window.SetTitle("IzAtom")
 	window.SetResizable(true)
 
+	var listener izatom.FrameListener
+	audio, err := newAudioOutput()
+	if err != nil {
+		messages.show(fmt.Sprintf("Error opening the audio device: %v", err))
+	} else {
+		defer audio.close()
+		listener = audio.queue
+		a.SetFrameListener(listener)
+	}
+
+	var pad *gamepad
+	if *joystickMode != "none" {
+		pad, err = newGamepad(a)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error opening the game controllers: %v", err))
+		}
+	}
+
+	bindings, err := loadBindings(*keysFile)
+	if err != nil {
+		messages.show(fmt.Sprintf("Error loading the key bindings, using the defaults: %v", err))
+		bindings, _ = loadBindings("")
+	}
+
+	var logical *logicalKeyboard
+	if *keyboardMode == "logical" {
+		logical = newLogicalKeyboard(a)
+	}
+
+	filterIndex := 0
+	var rec *recorder
+	var img *image.RGBA
+
+	updateTitle := func() {
+		title := "IzAtom"
+		if filterIndex != 0 {
+			title += " - " + filters[filterIndex].name
+		}
+		if a.IsPaused() {
+			title += " - paused"
+		} else if a.IsWarp() {
+			title += " - turbo"
+		} else if a.Speed() != 1 {
+			title += fmt.Sprintf(" - x%v", a.Speed())
+		}
+		window.SetTitle(title)
+	}
+
+	halted := false // Run has returned, there is no emulation to receive the keys
+	actions := map[string]func(pressed bool){
+		"reset": func(pressed bool) {
+			if !halted {
+				a.SendKey(izatom.KEY_BREAK, !pressed)
+			}
+		},
+		"filter": func(pressed bool) {
+			if pressed {
+				filterIndex = (filterIndex + 1) % len(filters)
+				updateTitle()
+			}
+		},
+		"pause": func(pressed bool) {
+			if pressed {
+				if a.IsPaused() {
+					a.Resume()
+				} else {
+					a.Pause()
+				}
+				updateTitle()
+			}
+		},
+		"turbo": func(pressed bool) {
+			a.SetWarp(pressed)
+			updateTitle()
+		},
+		"faster": func(pressed bool) {
+			if pressed && a.Speed() < maxSpeed {
+				a.SetSpeed(a.Speed() * 2)
+				updateTitle()
+			}
+		},
+		"slower": func(pressed bool) {
+			if pressed && a.Speed() > minSpeed {
+				a.SetSpeed(a.Speed() / 2)
+				updateTitle()
+			}
+		},
+		"screenshot": func(pressed bool) {
+			if pressed && img != nil {
+				name, err := saveScreenshot(img)
+				if err != nil {
+					messages.show(fmt.Sprintf("Error saving screenshot: %v", err))
+				} else {
+					messages.show(fmt.Sprintf("Screenshot saved to %v", name))
+				}
+			}
+		},
+		"record": func(pressed bool) {
+			if pressed {
+				rec = toggleRecording(a, rec, *recordFormat, listener, &messages)
+			}
+		},
+		"commit": func(pressed bool) {
+			if pressed {
+				overlayAction(a, *overlay, "committed", a.CommitOverlay, &messages)
+			}
+		},
+		"discard": func(pressed bool) {
+			if pressed {
+				overlayAction(a, *overlay, "discarded", a.DiscardOverlay, &messages)
+			}
+		},
+	}
+
 	running := true
+	desynced := false
 	for running {
+		if !halted {
+			select {
+			case err := <-runErr:
+				// Keep the window open with the last screen and the error
+				halted = true
+				if err != nil {
+					messages.showSticky(err.Error())
+				}
+			default:
+			}
+		}
+		if err := a.MovieError(); err != nil && !desynced {
+			desynced = true
+			messages.show(err.Error())
+		}
+
 		// Handle events
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch e := event.(type) {
 			case *sdl.QuitEvent:
 				running = false
 			case *sdl.KeyboardEvent:
-				sendKey(a, e)
+				t, ok := bindings.event(e)
+				released := e.State == sdl.RELEASED
+				if !ok {
+					// Not bound
+				} else if t.action != "" {
+					if e.Repeat == 0 {
+						actions[t.action](!released)
+					}
+				} else if halted {
+					// No emulation to receive the keys
+				} else if logical != nil {
+					logical.sendKey(t.key, released)
+				} else {
+					a.SendKey(t.key, released)
+				}
+			case *sdl.TextInputEvent:
+				if logical != nil && !halted {
+					logical.sendText(e)
+				}
+			default:
+				if pad != nil && !halted {
+					pad.event(event)
+				}
 			}
 		}
+		if logical != nil && !halted {
+			logical.tick()
+		}
 
 		// Draw
-		img := a.Snapshot()
+		// The filter is applied before the OSD, to keep it readable
+		img = a.Snapshot()
+		display := img
+		filter := filters[filterIndex]
+		if display != nil && filter.appliesTo(a.VideoMode()) {
+			display = filter.apply(display)
+		}
+		display = messages.draw(display)
+		if *driveLeds {
+			display = drawDrives(display, []izatom.DriveStatus{a.DriveStatus(0), a.DriveStatus(1)})
+		}
 
-		if img != nil {
-			surface, err := sdl.CreateRGBSurfaceFrom(unsafe.Pointer(&img.Pix[0]),
-				int32(img.Bounds().Dx()), int32(img.Bounds().Dy()),
-				32, 4*img.Bounds().Dx(),
+		if display != nil {
+			surface, err := sdl.CreateRGBSurfaceFrom(unsafe.Pointer(&display.Pix[0]),
+				int32(display.Bounds().Dx()), int32(display.Bounds().Dy()),
+				32, 4*display.Bounds().Dx(),
 				0x0000ff, 0x0000ff00, 0x00ff0000, 0xff000000)
 			// Valid for little endian. Should we reverse for big endian?
 			// 0xff000000, 0x00ff0000, 0x0000ff00, 0x000000ff)
 
 			if err != nil {
-				panic(err)
+				fmt.Fprintf(os.Stderr, "Error creating the surface: %v\n", err)
+				sdl.Delay(1000 / 30)
+				continue
 			}
 
 			texture, err := renderer.CreateTextureFromSurface(surface)
 			if err != nil {
-				panic(err)
+				fmt.Fprintf(os.Stderr, "Error creating the texture: %v\n", err)
+				surface.Free()
+				sdl.Delay(1000 / 30)
+				continue
+			}
+
+			var dst *sdl.Rect
+			if filter.pixelPerfect {
+				windowWidth, windowHeight := window.GetSize()
+				dst = pixelPerfectRect(display.Bounds().Dx(), display.Bounds().Dy(),
+					windowWidth, windowHeight)
 			}
 
 			renderer.Clear()
-			renderer.Copy(texture, nil, nil)
+			renderer.Copy(texture, nil, dst)
 			renderer.Present()
 			surface.Free()
 			texture.Destroy()
@@ -71,4 +337,59 @@ func main() {
 		sdl.Delay(1000 / 30)
 	}
 
+	if rec != nil {
+		toggleRecording(a, rec, *recordFormat, listener, &messages)
+	}
+
+	if !halted {
+		cancel()
+		err = <-runErr
+	} else {
+		err = a.Halted()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	if *moviePlay != "" || *movieRecord != "" {
+		err = a.StopMovie()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error on the movie: %v\n", err)
+		}
+	}
+}
+
+// Commits or discards the overlays of the disks
+func overlayAction(a *izatom.Atom, overlay bool, done string, action func(drive int) error, messages *osd) {
+	if !overlay {
+		messages.show("The disks are not loaded with -overlay")
+		return
+	}
+	for drive := 0; drive < flag.NArg() && drive < 2; drive++ {
+		err := action(drive)
+		if err != nil {
+			messages.show(fmt.Sprintf("Error on the overlay of drive %v: %v", drive, err))
+			return
+		}
+	}
+	messages.show(fmt.Sprintf("Disk changes %v", done))
+}
+
+func toggleRecording(a *izatom.Atom, rec *recorder, format string, listener izatom.FrameListener, messages *osd) *recorder {
+	if rec != nil {
+		err := rec.stop()
+		if err != nil {
+			messages.show(fmt.Sprintf("Error recording %v: %v", rec.name, err))
+		} else {
+			messages.show(fmt.Sprintf("Recording saved to %v", rec.name))
+		}
+		return nil
+	}
+
+	rec, err := startRecording(a, format, listener)
+	if err != nil {
+		messages.show(fmt.Sprintf("Error starting the recording: %v", err))
+		return nil
+	}
+	messages.show(fmt.Sprintf("Recording to %v", rec.name))
+	return rec
 }
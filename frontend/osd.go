@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"time"
+
+	"github.com/ivanizag/izatom"
+)
+
+/*
+Messages shown over the bottom of the Atom screen, for errors and for
+the results of the frontend actions. And the drives activity on the top.
+*/
+
+const messageDuration = 3 * time.Second
+
+var (
+	messageForeground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	messageBackground = color.RGBA{0x80, 0x00, 0x00, 0xff}
+)
+
+type osd struct {
+	text   string
+	until  time.Time
+	sticky bool // Shown until the window is closed
+}
+
+func (o *osd) show(text string) {
+	if o.sticky {
+		return
+	}
+	o.text = text
+	o.until = time.Now().Add(messageDuration)
+}
+
+func (o *osd) showSticky(text string) {
+	o.text = text
+	o.sticky = true
+}
+
+// Returns a copy of the image with the message, or the same image if there is none
+func (o *osd) draw(img *image.RGBA) *image.RGBA {
+	if img == nil || o.text == "" || (!o.sticky && time.Now().After(o.until)) {
+		return img
+	}
+
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	copy(out.Pix, img.Pix)
+
+	lines := wrapText(o.text, b.Dx()/izatom.CharWidth)
+	top := b.Max.Y - len(lines)*izatom.CharHeight
+	draw.Draw(out, image.Rect(b.Min.X, top, b.Max.X, b.Max.Y),
+		&image.Uniform{messageBackground}, image.Point{}, draw.Src)
+	for i, line := range lines {
+		izatom.DrawText(out, image.Pt(b.Min.X, top+i*izatom.CharHeight), line,
+			messageForeground, messageBackground)
+	}
+	return out
+}
+
+// Splits the text in lines of up to width characters, breaking on spaces if possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		if line == "" {
+			line = word
+		} else if len(line)+1+len(word) <= width {
+			line += " " + word
+		} else {
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var (
+	ledIdle    = color.RGBA{0x80, 0x00, 0x00, 0xff}
+	ledReading = color.RGBA{0xff, 0x20, 0x20, 0xff}
+	ledBack    = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	ledText    = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+	ledWriting = color.RGBA{0xff, 0xa0, 0x20, 0xff}
+)
+
+/*
+Returns a copy of the image with the LED and the track of the drives that
+have the motor on on the top right corner, or the same image if none.
+*/
+func drawDrives(img *image.RGBA, drives []izatom.DriveStatus) *image.RGBA {
+	var out *image.RGBA
+	row := 0
+	for i, d := range drives {
+		if img == nil || !d.Motor {
+			continue
+		}
+		if out == nil {
+			b := img.Bounds()
+			out = image.NewRGBA(b)
+			copy(out.Pix, img.Pix)
+		}
+
+		// A LED and "0:12", the drive and the track
+		text := fmt.Sprintf("%v:%02d", i, d.Track)
+		width := izatom.CharWidth*(len(text)+1) + 2
+		top := out.Bounds().Min.Y + row*izatom.CharHeight
+		left := out.Bounds().Max.X - width
+		draw.Draw(out, image.Rect(left, top, left+width, top+izatom.CharHeight),
+			&image.Uniform{ledBack}, image.Point{}, draw.Src)
+		led := ledIdle
+		if d.Writing {
+			led = ledWriting
+		} else if d.Reading {
+			led = ledReading
+		}
+		draw.Draw(out, image.Rect(left+2, top+3, left+8, top+9),
+			&image.Uniform{led}, image.Point{}, draw.Src)
+		izatom.DrawText(out, image.Pt(left+izatom.CharWidth+2, top), text, ledText, ledBack)
+		row++
+	}
+	if out == nil {
+		return img
+	}
+	return out
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ivanizag/izatom"
+)
+
+/*
+Screenshots and recordings. The recordings are built from the frames
+emitted by the emulator, not from the frames shown on the window, so
+the result does not depend on the speed of the host.
+
+Recording formats:
+  - gif: animated GIF, no audio. Kept in memory until stopped.
+  - y4m: YUV4MPEG2 video plus a WAV file with the speaker audio.
+*/
+
+func saveScreenshot(img *image.RGBA) (string, error) {
+	name := fileName("png")
+	return name, izatom.SavePNG(name, img)
+}
+
+func fileName(extension string) string {
+	return fmt.Sprintf("izatom-%v.%v", time.Now().Format("20060102-150405"), extension)
+}
+
+type frameWriter interface {
+	writeFrame(img *image.RGBA, audio []int16) error
+	close() error
+}
+
+type recorder struct {
+	a      *izatom.Atom
+	name   string
+	frames chan recordedFrame
+	done   chan error
+	next   izatom.FrameListener // The listener before the recording, nil if none
+}
+
+type recordedFrame struct {
+	img   *image.RGBA
+	audio []int16
+}
+
+func startRecording(a *izatom.Atom, format string, next izatom.FrameListener) (*recorder, error) {
+	var w frameWriter
+	var err error
+	var r recorder
+	switch format {
+	case "gif":
+		r.name = fileName("gif")
+		w, err = newGifWriter(r.name, a.FrameRate())
+	case "y4m":
+		r.name = fileName("y4m")
+		w, err = newY4mWriter(r.name, a.FrameRate())
+	default:
+		err = fmt.Errorf("unknown recording format %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.a = a
+	r.next = next
+	r.frames = make(chan recordedFrame, 60)
+	r.done = make(chan error)
+	go func() {
+		var err error
+		for frame := range r.frames {
+			if err == nil {
+				err = w.writeFrame(frame.img, frame.audio)
+			}
+		}
+		errClose := w.close()
+		if err == nil {
+			err = errClose
+		}
+		r.done <- err
+	}()
+
+	a.SetFrameListener(func(img *image.RGBA, audio []int16) {
+		if r.next != nil {
+			r.next(img, audio)
+		}
+		// Blocks the emulation if the writer can't keep up, no frames are lost
+		r.frames <- recordedFrame{img, append([]int16(nil), audio...)}
+	})
+	return &r, nil
+}
+
+func (r *recorder) stop() error {
+	r.a.SetFrameListener(r.next)
+	close(r.frames)
+	return <-r.done
+}
+
+type gifWriter struct {
+	f         *os.File
+	frameRate int
+	frame     int
+	anim      gif.GIF
+	previous  *image.RGBA
+}
+
+func newGifWriter(name string, frameRate int) (*gifWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &gifWriter{
+		f:         f,
+		frameRate: frameRate,
+	}, nil
+}
+
+// Delay of the frame in 100ths of second, rounded to keep the total time exact.
+func (w *gifWriter) delay() int {
+	return (w.frame+1)*100/w.frameRate - w.frame*100/w.frameRate
+}
+
+func (w *gifWriter) writeFrame(img *image.RGBA, _ []int16) error {
+	delay := w.delay()
+	w.frame++
+
+	last := len(w.anim.Image) - 1
+	if w.previous != nil && equalImages(w.previous, img) {
+		// Same image, extend the previous frame
+		w.anim.Delay[last] += delay
+		return nil
+	}
+	w.previous = img
+
+	w.anim.Image = append(w.anim.Image, toPaletted(img))
+	w.anim.Delay = append(w.anim.Delay, delay)
+	return nil
+}
+
+func (w *gifWriter) close() error {
+	var err error
+	if len(w.anim.Image) > 0 {
+		err = gif.EncodeAll(w.f, &w.anim)
+	}
+	errClose := w.f.Close()
+	if err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func equalImages(a *image.RGBA, b *image.RGBA) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The Atom uses a few colors, a palette with the colors of the image is enough.
+func toPaletted(img *image.RGBA) *image.Paletted {
+	var p color.Palette
+	index := make(map[color.RGBA]uint8)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		if _, ok := index[c]; !ok {
+			if len(p) == 256 {
+				// Too many colors, quantize
+				out := image.NewPaletted(img.Bounds(), palette.Plan9)
+				draw.Draw(out, img.Bounds(), img, image.Point{}, draw.Src)
+				return out
+			}
+			index[c] = uint8(len(p))
+			p = append(p, c)
+		}
+	}
+
+	out := image.NewPaletted(img.Bounds(), p)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		out.Pix[i/4] = index[c]
+	}
+	return out
+}
+
+type y4mWriter struct {
+	video     *os.File
+	videoBuf  *bufio.Writer
+	audio     *os.File
+	audioBuf  *bufio.Writer
+	frameRate int
+	width     int
+	height    int
+	samples   int
+	planes    []uint8
+}
+
+func newY4mWriter(name string, frameRate int) (*y4mWriter, error) {
+	video, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := os.Create(name[:len(name)-len("y4m")] + "wav")
+	if err != nil {
+		video.Close()
+		return nil, err
+	}
+
+	w := &y4mWriter{
+		video:     video,
+		videoBuf:  bufio.NewWriter(video),
+		audio:     audio,
+		audioBuf:  bufio.NewWriter(audio),
+		frameRate: frameRate,
+	}
+	// The header is rewritten with the sizes on close
+	err = w.writeWavHeader()
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *y4mWriter) writeFrame(img *image.RGBA, audio []int16) error {
+	b := img.Bounds()
+	if w.width == 0 {
+		w.width, w.height = b.Dx(), b.Dy()
+		_, err := fmt.Fprintf(w.videoBuf, "YUV4MPEG2 W%v H%v F%v:1 Ip A1:1 C444\n",
+			w.width, w.height, w.frameRate)
+		if err != nil {
+			return err
+		}
+		w.planes = make([]uint8, 3*w.width*w.height)
+	} else if b.Dx() != w.width || b.Dy() != w.height {
+		return fmt.Errorf("the frame size changed while recording")
+	}
+
+	planeSize := w.width * w.height
+	for i := 0; i < planeSize; i++ {
+		p := img.Pix[i*4:]
+		y, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+		w.planes[i] = y
+		w.planes[planeSize+i] = cb
+		w.planes[2*planeSize+i] = cr
+	}
+	_, err := w.videoBuf.WriteString("FRAME\n")
+	if err != nil {
+		return err
+	}
+	_, err = w.videoBuf.Write(w.planes)
+	if err != nil {
+		return err
+	}
+
+	w.samples += len(audio)
+	return binary.Write(w.audioBuf, binary.LittleEndian, audio)
+}
+
+func (w *y4mWriter) writeWavHeader() error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+		headerSize    = 44
+	)
+	dataSize := uint32(w.samples * channels * bitsPerSample / 8)
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(headerSize - 8 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16), // fmt chunk size
+		uint16(1),  // PCM
+		uint16(channels),
+		uint32(izatom.AudioSampleRate),
+		uint32(izatom.AudioSampleRate * channels * bitsPerSample / 8),
+		uint16(channels * bitsPerSample / 8),
+		uint16(bitsPerSample),
+		[4]byte{'d', 'a', 't', 'a'},
+		dataSize,
+	}
+	for _, field := range header {
+		err := binary.Write(w.audioBuf, binary.LittleEndian, field)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *y4mWriter) close() error {
+	errs := []error{
+		w.videoBuf.Flush(),
+		w.video.Close(),
+		w.audioBuf.Flush(),
+	}
+	_, err := w.audio.Seek(0, io.SeekStart)
+	errs = append(errs, err)
+	if err == nil {
+		errs = append(errs, w.writeWavHeader(), w.audioBuf.Flush())
+	}
+	errs = append(errs, w.audio.Close())
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
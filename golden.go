@@ -0,0 +1,108 @@
+package izatom
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+)
+
+/*
+Comparison of the screens, as returned by Snapshot or received by the
+frame listener, with golden PNG files. For the regression tests of the
+emulator and of the software run on it.
+*/
+
+// Tolerance is the difference allowed by CompareWithPNG, the zero value is an exact match
+type Tolerance struct {
+	Channel uint8 // Maximum difference on each of R, G, B and A
+	Pixels  int   // Number of pixels allowed to differ by more than Channel
+}
+
+/*
+CompareImages returns the number of pixels that differ by more than the
+channel tolerance and the first of them. An error is returned if the
+sizes differ.
+*/
+func CompareImages(img image.Image, golden image.Image, channel uint8) (int, image.Point, error) {
+	if img.Bounds().Size() != golden.Bounds().Size() {
+		return 0, image.Point{}, fmt.Errorf("the size is %v, not %v",
+			img.Bounds().Size(), golden.Bounds().Size())
+	}
+
+	differences := 0
+	var first image.Point
+	b := img.Bounds()
+	offset := golden.Bounds().Min.Sub(b.Min)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, a1 := img.At(x, y).RGBA()
+			r2, g2, b2, a2 := golden.At(x+offset.X, y+offset.Y).RGBA()
+			if channelDiffers(r1, r2, channel) || channelDiffers(g1, g2, channel) ||
+				channelDiffers(b1, b2, channel) || channelDiffers(a1, a2, channel) {
+				if differences == 0 {
+					first = image.Pt(x, y)
+				}
+				differences++
+			}
+		}
+	}
+	return differences, first, nil
+}
+
+// The channels are 16 bits, the tolerance is for 8 bits
+func channelDiffers(v1 uint32, v2 uint32, tolerance uint8) bool {
+	c1, c2 := v1>>8, v2>>8
+	if c1 > c2 {
+		return c1-c2 > uint32(tolerance)
+	}
+	return c2-c1 > uint32(tolerance)
+}
+
+/*
+CompareWithPNG compares the image with a golden PNG file. It returns nil
+if they match within the tolerance, or an error with the differences.
+*/
+func CompareWithPNG(img image.Image, path string, tolerance Tolerance) error {
+	golden, err := LoadPNG(path)
+	if err != nil {
+		return err
+	}
+	differences, first, err := CompareImages(img, golden, tolerance.Channel)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	if differences > tolerance.Pixels {
+		return fmt.Errorf("%v: %v pixels differ, the first at %v,%v",
+			path, differences, first.X, first.Y)
+	}
+	return nil
+}
+
+// LoadPNG reads an image from a PNG file
+func LoadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return img, nil
+}
+
+// SavePNG writes an image on a PNG file, to create or update a golden file
+func SavePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
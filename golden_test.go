@@ -0,0 +1,52 @@
+package izatom
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareImages(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	golden := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{0x10, 0, 0, 0})
+	img.Set(3, 3, color.RGBA{0, 0, 0x04, 0})
+
+	differences, first, err := CompareImages(img, golden, 0)
+	if err != nil || differences != 2 || first != image.Pt(1, 2) {
+		t.Errorf("exact: %v differences, the first at %v, %v", differences, first, err)
+	}
+	differences, first, err = CompareImages(img, golden, 0x04)
+	if err != nil || differences != 1 || first != image.Pt(1, 2) {
+		t.Errorf("tolerance 4: %v differences, the first at %v, %v", differences, first, err)
+	}
+	differences, _, err = CompareImages(img, golden, 0x10)
+	if err != nil || differences != 0 {
+		t.Errorf("tolerance 16: %v differences, %v", differences, err)
+	}
+	_, _, err = CompareImages(img, image.NewRGBA(image.Rect(0, 0, 4, 5)), 0)
+	if err == nil {
+		t.Error("the sizes differ and there is no error")
+	}
+}
+
+func TestCompareWithPNG(t *testing.T) {
+	a := bootAtom(t)
+	path := filepath.Join(t.TempDir(), "boot.png")
+	err := SavePNG(path, a.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CompareWithPNG(a.Snapshot(), path, Tolerance{}); err != nil {
+		t.Error(err)
+	}
+
+	typeLines(t, a, "PRINT 1")
+	if err = CompareWithPNG(a.Snapshot(), path, Tolerance{}); err == nil {
+		t.Error("the screen has changed and there is no error")
+	}
+	if err = CompareWithPNG(a.Snapshot(), path, Tolerance{Channel: 0xff}); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,5 +1,7 @@
 package izatom
 
+import "fmt"
+
 const (
 	INS8255_PORT_A = 0
 	INS8255_PORT_B = 1
@@ -29,16 +31,40 @@ func (i *ins8255) write(port uint8, value uint8) {
 	case 1:
 		i.ports[port] = value
 	case 2:
-		//panic("TODO: write in port C")
 		i.ports[port] = value
+		i.portCChanged()
 	case 3:
-		i.control = value
+		if value&0x80 == 0 {
+			// Bit set/reset of port C
+			bit := (value >> 1) & 0x07
+			if value&0x01 != 0 {
+				i.ports[INS8255_PORT_C] |= 1 << bit
+			} else {
+				i.ports[INS8255_PORT_C] &^= 1 << bit
+			}
+			i.portCChanged()
+		} else {
+			i.control = value
+		}
 	default:
-		panic("invalid port")
+		i.a.halt(fmt.Errorf("invalid 8255 port %v on write", port))
 	}
 }
 
 func (i *ins8255) read(port uint8) uint8 {
+	if port > 3 {
+		i.a.halt(fmt.Errorf("invalid 8255 port %v on read", port))
+		return 0xff
+	}
+	value := i.peek(port)
+	if port == INS8255_PORT_B {
+		i.ports[INS8255_PORT_B] = value
+	}
+	return value
+}
+
+// Returns the value of a port as read would, without side effects
+func (i *ins8255) peek(port uint8) uint8 {
 	switch port {
 	case 0:
 		return i.ports[port]
@@ -49,15 +75,18 @@ func (i *ins8255) read(port uint8) uint8 {
 	case 3:
 		return i.control
 	default:
-		panic("invalid address")
+		return 0xff
 	}
 }
 
+func (i *ins8255) portCChanged() {
+	// PC2 is the speaker
+	i.a.speaker.setLevel(i.ports[INS8255_PORT_C]&0x04 != 0, i.a.cpu.GetCycles())
+}
+
 func (i *ins8255) readPortB() uint8 {
-	pa0_3 := i.ports[INS8255_PORT_A&0x0f]
-	pb := i.a.keyboard.getPB(pa0_3)
-	i.ports[INS8255_PORT_B] = pb
-	return pb
+	pa0_3 := i.ports[INS8255_PORT_A] & 0x0f
+	return i.a.keyboard.getPB(pa0_3)
 }
 
 func (i *ins8255) readPortC() uint8 {
@@ -0,0 +1,135 @@
+package izatom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+The IRQ line of the 6502 is shared by the devices as a wired-OR: it is
+asserted while any of the sources asserts it. It is level triggered, the
+interrupt is taken between instructions while the line is asserted and
+the I flag is clear.
+
+iz6502 has no IRQ input, the interrupt sequence is done here as on the
+6502: push PC and P with B clear, set I and jump to the vector at #FFFE.
+*/
+
+const (
+	vectorIRQ    = 0xfffe
+	stackAddress = 0x0100
+	irqCycles    = 7
+
+	flagB uint8 = 1 << 4
+	flag5 uint8 = 1 << 5
+	flagI uint8 = 1 << 2
+)
+
+type irqLine struct {
+	sources  []*irqSource
+	asserted int // Number of sources asserting the line
+}
+
+type irqSource struct {
+	line     *irqLine
+	name     string
+	asserted bool
+}
+
+func (l *irqLine) addSource(name string) *irqSource {
+	s := &irqSource{line: l, name: name}
+	l.sources = append(l.sources, s)
+	return s
+}
+
+func (s *irqSource) set(asserted bool) {
+	if asserted == s.asserted {
+		return
+	}
+	s.asserted = asserted
+	if asserted {
+		s.line.asserted++
+	} else {
+		s.line.asserted--
+	}
+}
+
+func (l *irqLine) isAsserted() bool {
+	return l.asserted > 0
+}
+
+// Takes the interrupt if the line is asserted and the CPU has them enabled
+func (a *Atom) serviceIRQ() {
+	if !a.irq.isAsserted() {
+		return
+	}
+	_, _, _, p := a.cpu.GetAXYP()
+	if p&flagI != 0 {
+		return
+	}
+
+	pc, sp := a.cpu.GetPCAndSP()
+	push := func(value uint8) {
+		a.Poke(stackAddress+uint16(sp), value)
+		sp--
+	}
+	push(uint8(pc >> 8))
+	push(uint8(pc))
+	push((p &^ flagB) | flag5)
+	vector := uint16(a.Peek(vectorIRQ)) | uint16(a.Peek(vectorIRQ+1))<<8
+	err := a.setCPUState(p|flagI, sp, vector, a.cpu.GetCycles()+irqCycles)
+	if err != nil {
+		a.halt(err)
+	}
+}
+
+/*
+iz6502 has setters for P and PC, but not for SP and the cycle counter.
+They are changed on the state of Save and Load, this depends on its
+layout: the cycles as a big endian uint64 and then the registers A, X,
+Y, P, SP, PCH and PCL.
+*/
+func (a *Atom) setCPUState(p uint8, sp uint8, pc uint16, cycles uint64) error {
+	const regP, regSP, regPCH, regPCL = 3, 4, 5, 6
+	const stateSize = 8 + 7
+
+	var buf bytes.Buffer
+	err := a.cpu.Save(&buf)
+	if err != nil {
+		return err
+	}
+	state := buf.Bytes()
+	if len(state) != stateSize {
+		return fmt.Errorf("the CPU state is %v bytes, not %v", len(state), stateSize)
+	}
+	binary.BigEndian.PutUint64(state[0:8], cycles)
+	regs := state[8:]
+	regs[regP] = p
+	regs[regSP] = sp
+	regs[regPCH] = uint8(pc >> 8)
+	regs[regPCL] = uint8(pc)
+	return a.cpu.Load(bytes.NewReader(state))
+}
+
+// InterruptSource is the state of a device connected to the IRQ line
+type InterruptSource struct {
+	Name     string
+	Asserted bool
+}
+
+// InterruptSources returns the state of the IRQ sources at the end of the last frame
+func (a *Atom) InterruptSources() []InterruptSource {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	sources := make([]InterruptSource, len(a.publishedIRQ))
+	copy(sources, a.publishedIRQ)
+	return sources
+}
+
+func (a *Atom) captureIRQ() {
+	a.publishedIRQ = a.publishedIRQ[:0]
+	for _, s := range a.irq.sources {
+		a.publishedIRQ = append(a.publishedIRQ, InterruptSource{s.name, s.asserted})
+	}
+}
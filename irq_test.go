@@ -0,0 +1,81 @@
+package izatom
+
+import "testing"
+
+// A device without registers that asserts the IRQ line when told to
+type irqDevice struct {
+	irq bool
+}
+
+func (d *irqDevice) Name() string                     { return "irq" }
+func (d *irqDevice) Read(offset uint16) uint8         { return 0xff }
+func (d *irqDevice) Peek(offset uint16) uint8         { return 0xff }
+func (d *irqDevice) Write(offset uint16, value uint8) {}
+func (d *irqDevice) Tick(cycle uint64)                {}
+func (d *irqDevice) Reset()                           {}
+func (d *irqDevice) IRQ() bool                        { return d.irq }
+func (d *irqDevice) NMI() bool                        { return false }
+func (d *irqDevice) SaveState() ([]byte, error)       { return nil, nil }
+func (d *irqDevice) LoadState(state []byte) error     { return nil }
+
+func TestIRQ(t *testing.T) {
+	const carry = 0x01 // To check that P is kept
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &irqDevice{}
+	err = a.AttachDevice(device, DeviceArea{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CLI, NOP, JMP #2801
+	for i, b := range []uint8{0x58, 0xea, 0x4c, 0x01, 0x28} {
+		a.Poke(0x2800+uint16(i), b)
+	}
+	a.cpu.SetPC(0x2800)
+	a.cpu.SetAXYP(0, 0, 0, flagI|carry)
+
+	// With the line not asserted
+	a.step()
+	if pc, _ := a.cpu.GetPCAndSP(); pc != 0x2801 {
+		t.Fatalf("PC is #%04x after CLI, not #2801", pc)
+	}
+
+	// The interrupt is taken after the NOP
+	device.irq = true
+	_, sp := a.cpu.GetPCAndSP()
+	cycles := a.cpu.GetCycles()
+	a.step()
+
+	vector := uint16(a.Peek(vectorIRQ)) | uint16(a.Peek(vectorIRQ+1))<<8
+	pc, spAfter := a.cpu.GetPCAndSP()
+	if pc != vector {
+		t.Errorf("PC is #%04x, not the vector #%04x", pc, vector)
+	}
+	if spAfter != sp-3 {
+		t.Errorf("SP is #%02x, not #%02x", spAfter, sp-3)
+	}
+	stacked := func(offset uint8) uint8 {
+		return a.Peek(stackAddress + uint16(sp-offset))
+	}
+	if pcStacked := uint16(stacked(0))<<8 | uint16(stacked(1)); pcStacked != 0x2802 {
+		t.Errorf("the PC stacked is #%04x, not #2802", pcStacked)
+	}
+	if pStacked := stacked(2); pStacked != flag5|carry {
+		t.Errorf("the P stacked is #%02x, not #%02x", pStacked, flag5|carry)
+	}
+	if _, _, _, p := a.cpu.GetAXYP(); p != flagI|carry {
+		t.Errorf("P is #%02x, not #%02x", p, flagI|carry)
+	}
+	if c := a.cpu.GetCycles() - cycles; c != 2+irqCycles {
+		t.Errorf("the NOP and the interrupt took %v cycles, not %v", c, 2+irqCycles)
+	}
+
+	// With I set, the interrupt is not taken again
+	a.step()
+	if _, sp := a.cpu.GetPCAndSP(); sp < spAfter-1 {
+		t.Errorf("SP is #%02x, the interrupt was taken again", sp)
+	}
+}
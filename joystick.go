@@ -0,0 +1,112 @@
+package izatom
+
+const (
+	JOYSTICK_UP    = 0
+	JOYSTICK_DOWN  = 1
+	JOYSTICK_LEFT  = 2
+	JOYSTICK_RIGHT = 3
+	JOYSTICK_FIRE  = 4
+	JOYSTICK_SIZE  = 5 // Number of buttons
+)
+
+/*
+A joystick can be connected in two ways:
+  - Keys: the joystick is wired to the keyboard matrix, each direction
+    and the fire button press an Atom key.
+  - VIA: the joystick is connected to port B of the 6522, active low:
+    PB0 right, PB1 left, PB2 down, PB3 up and PB4 fire.
+*/
+
+type JoystickMode int
+
+const (
+	JoystickNone JoystickMode = iota
+	JoystickKeys
+	JoystickVIA
+)
+
+var joystickVIABits = [JOYSTICK_SIZE]uint8{
+	JOYSTICK_UP:    1 << 3,
+	JOYSTICK_DOWN:  1 << 2,
+	JOYSTICK_LEFT:  1 << 1,
+	JOYSTICK_RIGHT: 1 << 0,
+	JOYSTICK_FIRE:  1 << 4,
+}
+
+var defaultJoystickKeys = [JOYSTICK_SIZE]int{
+	JOYSTICK_UP:    KEY_COLON_ASTERISK,
+	JOYSTICK_DOWN:  KEY_SLASH_QUESTION,
+	JOYSTICK_LEFT:  KEY_Z,
+	JOYSTICK_RIGHT: KEY_X,
+	JOYSTICK_FIRE:  KEY_SPACE,
+}
+
+type joystick struct {
+	mode          JoystickMode
+	keys          [JOYSTICK_SIZE]int
+	buttonChannel chan int
+	isPressed     [JOYSTICK_SIZE]bool
+}
+
+func newJoystick() *joystick {
+	return &joystick{
+		keys:          defaultJoystickKeys,
+		buttonChannel: make(chan int, inputBufferSize),
+	}
+}
+
+func (j *joystick) sendButton(button int, released bool) {
+	if button < 0 || button >= JOYSTICK_SIZE {
+		return // Invalid button
+	}
+
+	if released {
+		button += KEY_IS_RELEASED
+	}
+	select {
+	case j.buttonChannel <- button:
+	default:
+		// Dropped, the emulation is not running
+	}
+}
+
+// Applies the buttons sent with the function provided
+func (j *joystick) processButtons(apply func(button int, released bool)) {
+	for {
+		select {
+		case button := <-j.buttonChannel:
+			if button >= KEY_IS_RELEASED {
+				apply(button-KEY_IS_RELEASED, true)
+			} else {
+				apply(button, false)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Returns true if a button mapped to the key is pressed
+func (j *joystick) isKeyPressed(key int) bool {
+	if j.mode != JoystickKeys {
+		return false
+	}
+	for button, buttonKey := range j.keys {
+		if buttonKey == key && j.isPressed[button] {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *joystick) getPB() uint8 {
+	var pb uint8 = 0xff // Pull-up resistors
+	if j.mode == JoystickVIA {
+		for button, bit := range joystickVIABits {
+			if j.isPressed[button] {
+				pb &^= bit
+			}
+		}
+	}
+	return pb
+}
@@ -1,5 +1,7 @@
 package izatom
 
+import "strings"
+
 const (
 	// Top row
 	KEY_ESC            = 0
@@ -111,17 +113,108 @@ var keyboardMatrix = [6][10]int{
 	{KEY_ESC, KEY_Z, KEY_Y, KEY_X, KEY_W, KEY_V, KEY_U, KEY_T, KEY_S, KEY_R},
 }
 
+// Names of the keys, as the constants without the KEY_ prefix
+var keyNames = [KEY_NONE]string{
+	"ESC",
+	"1_BANG",
+	"2_DQUOTE",
+	"3_HASH",
+	"4_DOLLAR",
+	"5_PERCENT",
+	"6_AMP",
+	"7_QUOTE",
+	"8_LPAREN",
+	"9_RPAREN",
+	"0",
+	"MINUS_EQUALS",
+	"COLON_ASTERISK",
+	"UP",
+	"BREAK",
+	"LEFT_RIGHT",
+	"COPY",
+	"Q",
+	"W",
+	"E",
+	"R",
+	"T",
+	"Y",
+	"U",
+	"I",
+	"O",
+	"P",
+	"AT",
+	"BACKSLASH",
+	"DELETE",
+	"UP_DOWN",
+	"CTRL",
+	"A",
+	"S",
+	"D",
+	"F",
+	"G",
+	"H",
+	"J",
+	"K",
+	"L",
+	"SEMICOLON_PLUS",
+	"LBRACKET",
+	"RBRACKET",
+	"RETURN",
+	"LOCK",
+	"LSHIFT",
+	"Z",
+	"X",
+	"C",
+	"V",
+	"B",
+	"N",
+	"M",
+	"COMMA_LESS",
+	"PERIOD_GREATER",
+	"SLASH_QUESTION",
+	"RSHIFT",
+	"REPT",
+	"SPACE",
+}
+
+// KeyName returns the name of a key, as the constant without the KEY_ prefix
+func KeyName(key int) string {
+	if key < 0 || key >= KEY_NONE {
+		return "NONE"
+	}
+	return keyNames[key]
+}
+
+// KeyByName returns the key with a name, as the constant without the KEY_ prefix
+func KeyByName(name string) (int, bool) {
+	for key, keyName := range keyNames {
+		if strings.EqualFold(name, keyName) {
+			return key, true
+		}
+	}
+	return KEY_NONE, false
+}
+
 type keyboard struct {
 	keyChannel chan int
 	isPressed  [KEY_SIZE]bool
+	joystick   *joystick
 }
 
-func newKeyboard() *keyboard {
+func newKeyboard(joystick *joystick) *keyboard {
 	return &keyboard{
-		keyChannel: make(chan int),
+		keyChannel: make(chan int, inputBufferSize),
+		joystick:   joystick,
 	}
 }
 
+/*
+The keys and buttons sent are buffered and never block. If the emulation
+is not consuming them, because it has not started or has halted, the
+events after the buffer is full are dropped.
+*/
+const inputBufferSize = 64
+
 func (k *keyboard) sendKey(key int, released bool) {
 	if key < 0 || key >= KEY_SIZE {
 		return // Invalid key
@@ -130,20 +223,24 @@ func (k *keyboard) sendKey(key int, released bool) {
 	if released {
 		key += KEY_IS_RELEASED
 	}
-	k.keyChannel <- key
+	select {
+	case k.keyChannel <- key:
+	default:
+		// Dropped, the emulation is not running
+	}
 }
 
-func (k *keyboard) processKeys() {
+// Applies the keys sent with the function provided
+func (k *keyboard) processKeys(apply func(key int, released bool)) {
 
 	for {
 		select {
 		case key := <-k.keyChannel:
 			if key >= KEY_IS_RELEASED {
-				key -= KEY_IS_RELEASED
-				k.isPressed[key] = false
+				apply(key-KEY_IS_RELEASED, true)
 				//fmt.Printf("[KEYBOARD] Key released: %d\n", key)
 			} else {
-				k.isPressed[key] = true
+				apply(key, false)
 				//fmt.Printf("[KEYBOARD] Key pressed: %d\n", key)
 			}
 		default:
@@ -153,19 +250,24 @@ func (k *keyboard) processKeys() {
 
 }
 
+// A key can be pressed on the keyboard or by a joystick wired to the matrix
+func (k *keyboard) isKeyPressed(key int) bool {
+	return k.isPressed[key] || k.joystick.isKeyPressed(key)
+}
+
 func (k *keyboard) getPB(pa0_3 uint8) uint8 {
 	var pb uint8 = 0xff // Pull-up resistors
 	if pa0_3 < 10 {
 		for i := 0; i < 6; i++ {
-			if k.isPressed[keyboardMatrix[i][pa0_3]] {
+			if k.isKeyPressed(keyboardMatrix[i][pa0_3]) {
 				pb &^= 1 << i
 			}
 		}
 	}
-	if k.isPressed[KEY_CTRL] {
+	if k.isKeyPressed(KEY_CTRL) {
 		pb &^= 1 << 6
 	}
-	if k.isPressed[KEY_LSHIFT] || k.isPressed[KEY_RSHIFT] {
+	if k.isKeyPressed(KEY_LSHIFT) || k.isKeyPressed(KEY_RSHIFT) {
 		pb &^= 1 << 7
 	}
 	return pb
@@ -176,5 +278,70 @@ func (k *keyboard) getBreak() bool {
 }
 
 func (k *keyboard) getRept() bool {
-	return k.isPressed[KEY_REPT]
+	return k.isKeyPressed(KEY_REPT)
+}
+
+type charKey struct {
+	key   int
+	shift bool
+}
+
+/*
+Characters that can be typed on the Atom keyboard. The letters are
+upper case without SHIFT, both cases are mapped to the unshifted key.
+*/
+var charKeys = map[rune]charKey{
+	' ':  {KEY_SPACE, false},
+	'0':  {KEY_0, false},
+	'1':  {KEY_1_BANG, false},
+	'!':  {KEY_1_BANG, true},
+	'2':  {KEY_2_DQUOTE, false},
+	'"':  {KEY_2_DQUOTE, true},
+	'3':  {KEY_3_HASH, false},
+	'#':  {KEY_3_HASH, true},
+	'4':  {KEY_4_DOLLAR, false},
+	'$':  {KEY_4_DOLLAR, true},
+	'5':  {KEY_5_PERCENT, false},
+	'%':  {KEY_5_PERCENT, true},
+	'6':  {KEY_6_AMP, false},
+	'&':  {KEY_6_AMP, true},
+	'7':  {KEY_7_QUOTE, false},
+	'\'': {KEY_7_QUOTE, true},
+	'8':  {KEY_8_LPAREN, false},
+	'(':  {KEY_8_LPAREN, true},
+	'9':  {KEY_9_RPAREN, false},
+	')':  {KEY_9_RPAREN, true},
+	'-':  {KEY_MINUS_EQUALS, false},
+	'=':  {KEY_MINUS_EQUALS, true},
+	':':  {KEY_COLON_ASTERISK, false},
+	'*':  {KEY_COLON_ASTERISK, true},
+	';':  {KEY_SEMICOLON_PLUS, false},
+	'+':  {KEY_SEMICOLON_PLUS, true},
+	',':  {KEY_COMMA_LESS, false},
+	'<':  {KEY_COMMA_LESS, true},
+	'.':  {KEY_PERIOD_GREATER, false},
+	'>':  {KEY_PERIOD_GREATER, true},
+	'/':  {KEY_SLASH_QUESTION, false},
+	'?':  {KEY_SLASH_QUESTION, true},
+	'^':  {KEY_UP, false},
+	'@':  {KEY_AT, false},
+	'[':  {KEY_LBRACKET, false},
+	'\\': {KEY_BACKSLASH, false},
+	']':  {KEY_RBRACKET, false},
+}
+
+func init() {
+	letters := []int{KEY_A, KEY_B, KEY_C, KEY_D, KEY_E, KEY_F, KEY_G, KEY_H, KEY_I,
+		KEY_J, KEY_K, KEY_L, KEY_M, KEY_N, KEY_O, KEY_P, KEY_Q, KEY_R, KEY_S,
+		KEY_T, KEY_U, KEY_V, KEY_W, KEY_X, KEY_Y, KEY_Z}
+	for i, key := range letters {
+		charKeys['A'+rune(i)] = charKey{key, false}
+		charKeys['a'+rune(i)] = charKey{key, false}
+	}
+}
+
+// KeyForChar returns the key, and if SHIFT is needed, to type a character
+func KeyForChar(ch rune) (key int, shift bool, ok bool) {
+	k, ok := charKeys[ch]
+	return k.key, k.shift, ok
 }
@@ -0,0 +1,120 @@
+package izatom
+
+import "testing"
+
+func TestGetPBMatrix(t *testing.T) {
+	for bit, keys := range keyboardMatrix {
+		for row, key := range keys {
+			if key == KEY_NONE {
+				continue
+			}
+			k := newKeyboard(newJoystick())
+			k.isPressed[key] = true
+			for pa := uint8(0); pa < 16; pa++ {
+				expected := uint8(0xff)
+				if int(pa) == row {
+					expected &^= 1 << bit
+				}
+				if pb := k.getPB(pa); pb != expected {
+					t.Errorf("%v on row %v: PB is 0x%02x, not 0x%02x", keyNames[key], pa, pb, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestGetPBModifiers(t *testing.T) {
+	modifiers := []struct {
+		key int
+		pb  uint8
+	}{
+		{KEY_CTRL, 0xbf},   // PB6
+		{KEY_LSHIFT, 0x7f}, // PB7
+		{KEY_RSHIFT, 0x7f},
+	}
+	for _, m := range modifiers {
+		k := newKeyboard(newJoystick())
+		k.isPressed[m.key] = true
+		for pa := uint8(0); pa < 16; pa++ {
+			if pb := k.getPB(pa); pb != m.pb {
+				t.Errorf("%v on row %v: PB is 0x%02x, not 0x%02x", keyNames[m.key], pa, pb, m.pb)
+			}
+		}
+	}
+}
+
+func TestGetPBNoKeys(t *testing.T) {
+	k := newKeyboard(newJoystick())
+	for pa := uint8(0); pa < 16; pa++ {
+		if pb := k.getPB(pa); pb != 0xff {
+			t.Errorf("row %v: PB is 0x%02x with no keys pressed", pa, pb)
+		}
+	}
+}
+
+func TestMatrixHasAllKeys(t *testing.T) {
+	found := map[int]bool{}
+	for _, keys := range keyboardMatrix {
+		for _, key := range keys {
+			if key != KEY_NONE && found[key] {
+				t.Errorf("%v is twice on the matrix", keyNames[key])
+			}
+			found[key] = true
+		}
+	}
+	// Outside of the matrix: on PB6, PB7, PC6 and the reset line
+	for _, key := range []int{KEY_CTRL, KEY_LSHIFT, KEY_RSHIFT, KEY_REPT, KEY_BREAK} {
+		found[key] = true
+	}
+	for key := 0; key < KEY_NONE; key++ {
+		if !found[key] {
+			t.Errorf("%v is not on the matrix", keyNames[key])
+		}
+	}
+}
+
+/*
+The rows are selected with PA0-3 on #B000 and read with PB0-5 on #B001.
+PA4-7 are the video mode, set here to check that they don't change the
+row selected.
+*/
+func TestKeyboardThroughPPIA(t *testing.T) {
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bit, keys := range keyboardMatrix {
+		for row, key := range keys {
+			if key == KEY_NONE {
+				continue
+			}
+			a.keyboard.isPressed[key] = true
+			for _, mode := range []uint8{0x00, 0xf0} {
+				for pa := uint8(0); pa < 16; pa++ {
+					a.Poke(ppiaStart, mode|pa)
+					expected := uint8(0xff)
+					if int(pa) == row {
+						expected &^= 1 << bit
+					}
+					if pb := a.Peek(ppiaStart + INS8255_PORT_B); pb != expected {
+						t.Errorf("%v with #%02x on port A: PB is 0x%02x, not 0x%02x",
+							keyNames[key], mode|pa, pb, expected)
+					}
+				}
+			}
+			a.keyboard.isPressed[key] = false
+		}
+	}
+}
+
+func TestSendKeyDoesNotBlock(t *testing.T) {
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing consumes the keys, as after a halt
+	for i := 0; i < 2*inputBufferSize; i++ {
+		a.SendKey(KEY_A, i%2 == 1)
+		a.SendJoystick(JOYSTICK_FIRE, i%2 == 1)
+	}
+}
@@ -3,6 +3,7 @@ package izatom
 import (
 	"image"
 	"image/color"
+	"image/draw"
 )
 
 /*
@@ -10,21 +11,117 @@ See the Motorola MC6847 datasheet for more information.
 */
 
 type mc6847 struct {
-	a *Atom
+	a      *Atom
+	timing *videoTiming
+	border bool
+
+	fontVariant FontVariant
+	extFont     []uint8
+	intExt      IntExtMode
 }
 
+type VideoStandard int
+
+const (
+	VideoNTSC VideoStandard = iota
+	VideoPAL
+)
+
+/*
+Frame geometry for each video standard. The MC6847 generates a
+262 lines NTSC frame. The European Atom used a PAL colour encoder
+that stretches the vertical blanking to get 312 lines at 50Hz.
+
+The visible border sizes are what a typical monitor shows around the
+256x192 active area.
+*/
+type videoTiming struct {
+	linesPerFrame int
+	frameRate     int
+	borderTop     int
+	borderBottom  int
+	borderLeft    int
+	borderRight   int
+}
+
+var videoTimings = map[VideoStandard]*videoTiming{
+	VideoNTSC: {262, 60, 25, 26, 32, 32},
+	VideoPAL:  {312, 50, 48, 48, 32, 32},
+}
+
+const (
+	activeWidth  = 256
+	activeHeight = 192
+)
+
 func NewMC6847(a *Atom) *mc6847 {
-	return &mc6847{a: a}
+	return &mc6847{
+		a:      a,
+		timing: videoTimings[VideoNTSC],
+	}
+}
+
+func (mc *mc6847) setStandard(standard VideoStandard) {
+	timing, ok := videoTimings[standard]
+	if ok {
+		mc.timing = timing
+	}
+}
+
+/*
+videoState is what the MC6847 needs to render a frame: the video memory
+and the mode pins from port A of the 8255. It is captured at the end of
+each frame.
+*/
+type videoState struct {
+	vram [videoMemorySize]uint8
+	pa   uint8
+}
+
+func (mc *mc6847) capture(s *videoState) {
+	copy(s.vram[:], mc.a.ram[videoMemoryStart:])
+	s.pa = mc.a.ppia.ports[INS8255_PORT_A]
+}
+
+func (s *videoState) mode() (bool, uint8) {
+	isGraphic := (s.pa & 0x10) != 0     // pin A/G, from PA4
+	graphicMode := ((s.pa >> 5) & 0x07) // pins GM0-1-2 from PA5-6-7
+	return isGraphic, graphicMode
 }
-func (mc *mc6847) snapshot() *image.RGBA {
-	pa := mc.a.ppia.read(INS8255_PORT_A)
-	isGraphic := (pa & 0x10) != 0 // pin A/G, from PA4
+
+func (mc *mc6847) snapshot(s *videoState) *image.RGBA {
+	isGraphic, _ := s.mode()
+
+	origin := image.Point{}
+	size := image.Rect(0, 0, activeWidth, activeHeight)
+	if mc.border {
+		t := mc.timing
+		origin = image.Pt(t.borderLeft, t.borderTop)
+		size = image.Rect(0, 0,
+			t.borderLeft+activeWidth+t.borderRight,
+			t.borderTop+activeHeight+t.borderBottom)
+	}
+	img := image.NewRGBA(size)
+
+	if mc.border {
+		draw.Draw(img, size, &image.Uniform{mc.borderColor(isGraphic)}, image.Point{}, draw.Src)
+	}
 
 	if isGraphic {
-		return mc.snapshotGraphic()
+		mc.snapshotGraphic(img, origin, s)
 	} else {
-		return mc.snapshotText()
+		mc.snapshotText(img, origin, s)
 	}
+	return img
+}
+
+// The border is black on the alphanumeric modes and the color
+// set 0 background on the graphic modes.
+func (mc *mc6847) borderColor(isGraphic bool) color.RGBA {
+	if isGraphic {
+		return palette[0] // Green, as CSS is not connected
+	}
+	return colorBlack
 }
 
 // Colors taken from MAME, only the first 4 used as CSS is not connected
@@ -41,6 +138,7 @@ var palette = [8]color.RGBA{
 
 var textColorLight = color.RGBA{0x30, 0xd2, 0x00, 0xff}
 var textColorDark = color.RGBA{0x00, 0x7c, 0x00, 0xff}
+var colorBlack = color.RGBA{0x26, 0x30, 0x16, 0xff}
 
 //	rgb_t(0x26, 0x30, 0x16), /* BLACK */
 //	rgb_t(0x30, 0xd2, 0x00), /* GREEN */
@@ -52,27 +150,24 @@ var textColorDark = color.RGBA{0x00, 0x7c, 0x00, 0xff}
 //	rgb_t(0x6b, 0x27, 0x00), /* ALPHANUMERIC DARK ORANGE */
 //	rgb_t(0xff, 0xb7, 0x00)  /* ALPHANUMERIC BRIGHT ORANGE */
 
-func (mc *mc6847) snapshotText() *image.RGBA {
+func (mc *mc6847) snapshotText(img *image.RGBA, origin image.Point, s *videoState) {
 	/*
 		Chars are 8*12 pixels (2+5+1)*(3+7+2)
 		The screen is 32 rows, 16 lines
 
 		Two possible text modes per character:
-		- ascii with internal chars
+		- ascii with internal or external chars
 		- semigrahics6
 	*/
 
-	size := image.Rect(0, 0, 256, 192)
-	img := image.NewRGBA(size)
-
 	//	ch := uint8(0)
 	for line := 0; line < 16; line++ {
 		for charLine := 0; charLine < 12; charLine++ {
 			for col := 0; col < 32; col++ {
-				ch := mc.a.Peek(0x8000 + uint16(line*32+col))
+				ch := s.vram[line*32+col]
 				inverse := ch&0x80 != 0      // Bit 7
 				semigraphics := ch&0x40 != 0 // Bit 6
-				if semigraphics {
+				if semigraphics && !mc.isExternalChar(ch) {
 					// Semigraphics
 					// The chip supports 8 colors, but CSS is
 					// always 0 and C0 is 1
@@ -89,7 +184,7 @@ func (mc *mc6847) snapshotText() *image.RGBA {
 						color = lightColor
 					}
 					for dotRow := 0; dotRow < 4; dotRow++ {
-						img.Set(col*8+dotRow, line*12+charLine, color)
+						img.Set(origin.X+col*8+dotRow, origin.Y+line*12+charLine, color)
 					}
 					// Second half
 					pixel = (ch>>segment)&0x01 != 0
@@ -98,33 +193,27 @@ func (mc *mc6847) snapshotText() *image.RGBA {
 						color = lightColor
 					}
 					for dotRow := 4; dotRow < 8; dotRow++ {
-						img.Set(col*8+dotRow, line*12+charLine, color)
+						img.Set(origin.X+col*8+dotRow, origin.Y+line*12+charLine, color)
 					}
 				} else {
 					// Text
-					pixels := mc6847getFontLine(ch&0x3f, charLine)
+					pixels, inverse := mc.fontLine(ch, charLine)
 					for charRow := 7; charRow >= 0; charRow-- {
 						color := textColorDark
 						if (pixels&1 != 0) != inverse {
 							color = textColorLight
 						}
-						img.Set(col*8+charRow, line*12+charLine, color)
+						img.Set(origin.X+col*8+charRow, origin.Y+line*12+charLine, color)
 						pixels >>= 1
 					}
 				}
 			}
 		}
 	}
-
-	return img
 }
 
-func (mc *mc6847) snapshotGraphic() *image.RGBA {
-	pa := mc.a.ppia.read(INS8255_PORT_A)
-	graphicMode := ((pa >> 5) & 0x07) // pins GM0-1-2 from PA5-6-7
-
-	size := image.Rect(0, 0, 256, 192)
-	img := image.NewRGBA(size)
+func (mc *mc6847) snapshotGraphic(img *image.RGBA, origin image.Point, s *videoState) {
+	_, graphicMode := s.mode()
 
 	var columns int
 	var lines int
@@ -156,19 +245,19 @@ func (mc *mc6847) snapshotGraphic() *image.RGBA {
 		columns, lines, colorBits = 256, 192, 1
 	}
 
-	pixelWidth := 256 / columns
-	pixelHeight := 192 / lines
+	pixelWidth := activeWidth / columns
+	pixelHeight := activeHeight / lines
 	bytesPerLine := colorBits * columns / 8
 	pixelsPerByte := 8 / colorBits
 
-	pointer := uint16(0x8000)
+	pointer := 0
 	x := 0
-	y := 0
+	y := origin.Y
 	var color color.RGBA
 	for l := 0; l < lines; l++ {
-		x = 0
+		x = origin.X
 		for b := 0; b < bytesPerLine; b++ {
-			data := mc.a.Peek(pointer)
+			data := s.vram[pointer]
 			pointer++
 			for pixel := 0; pixel < pixelsPerByte; pixel++ {
 				if colorBits == 1 {
@@ -178,12 +267,11 @@ func (mc *mc6847) snapshotGraphic() *image.RGBA {
 						color = textColorDark
 					}
 					data <<= 1
-				} else if colorBits == 2 {
+				} else {
+					// 2 color bits
 					colorIndex := (data >> 6) & 0x03
 					color = palette[colorIndex]
 					data <<= 2
-				} else {
-					panic("invalid colorBits")
 				}
 				for i := 0; i < pixelWidth; i++ {
 					for j := 0; j < pixelHeight; j++ {
@@ -195,17 +283,25 @@ func (mc *mc6847) snapshotGraphic() *image.RGBA {
 		}
 		y += pixelHeight
 	}
-	return img
 }
 
 /*
-There are 262 lines per 60Hz frame, 192 of which are visible
-and 70 of which are blanking.
+There are 262 lines per 60Hz NTSC frame, 192 of which are visible
+and 70 of which are blanking. On PAL there are 312 lines per 50Hz
+frame with 120 lines of blanking.
 */
-const cpuCyclesPerFrame = 1_000_000 / 60 // 1Mhz / 60Hz
-const cpuCyclesPerFramBlanking = cpuCyclesPerFrame * 70 / 262
+const cpuCyclesPerSecond = 1_000_000 // 1Mhz
+
+func (mc *mc6847) cyclesPerFrame() uint64 {
+	return cpuCyclesPerSecond / uint64(mc.timing.frameRate)
+}
+
+func (mc *mc6847) cyclesPerFrameBlanking() uint64 {
+	t := mc.timing
+	return mc.cyclesPerFrame() * uint64(t.linesPerFrame-activeHeight) / uint64(t.linesPerFrame)
+}
 
 // Field Sync, true during the blanking period.
 func (mc *mc6847) fs() bool {
-	return mc.a.cpu.GetCycles()%cpuCyclesPerFrame < cpuCyclesPerFramBlanking
+	return mc.a.cpu.GetCycles()%mc.cyclesPerFrame() < mc.cyclesPerFrameBlanking()
 }
@@ -0,0 +1,66 @@
+package izatom
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files on testdata")
+
+/*
+Video memory with every character code twice on the alphanumeric screen
+and a pattern after it, for the bigger graphic modes.
+*/
+func testVideoState(pa uint8) *videoState {
+	var s videoState
+	s.pa = pa
+	for i := range s.vram {
+		if i < 512 {
+			s.vram[i] = uint8(i)
+		} else {
+			s.vram[i] = uint8(i*37) ^ uint8(i>>5)
+		}
+	}
+	return &s
+}
+
+func TestMC6847Modes(t *testing.T) {
+	modes := []struct {
+		name string
+		pa   uint8
+	}{
+		{"alphanumeric", 0x00},
+	}
+	for mode := uint8(0); mode < 8; mode++ {
+		modes = append(modes, struct {
+			name string
+			pa   uint8
+		}{fmt.Sprintf("graphic%v", mode), 0x10 | mode<<5})
+	}
+
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range modes {
+		img := a.vdu.snapshot(testVideoState(m.pa))
+		checkGolden(t, filepath.Join("testdata", "mc6847_"+m.name+".png"), img)
+	}
+}
+
+// Compares the image with the golden PNG, or replaces it with -update
+func checkGolden(t *testing.T, path string, img *image.RGBA) {
+	t.Helper()
+	var err error
+	if *update {
+		err = SavePNG(path, img)
+	} else {
+		err = CompareWithPNG(img, path, Tolerance{})
+	}
+	if err != nil {
+		t.Error(err)
+	}
+}
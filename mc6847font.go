@@ -1,5 +1,7 @@
 package izatom
 
+import "fmt"
+
 /*
 
 Contains the font data for the MC6847
@@ -76,6 +78,107 @@ var mc6847_fontdata8x12 = [64 * 12]uint8{
 	0x00, 0x00, 0x00, 0x18, 0x24, 0x04, 0x08, 0x08, 0x00, 0x08, 0x00, 0x00, /* ? */
 }
 
+/*
+The MC6847T1 variant replaces the inverse video of the first 32
+characters with lower case letters.
+*/
+var mc6847t1_lowercase8x12 = [32 * 12]uint8{
+	0x00, 0x00, 0x00, 0x10, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, /* ` */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1C, 0x02, 0x1E, 0x22, 0x1E, 0x00, 0x00, /* a */
+	0x00, 0x00, 0x00, 0x20, 0x20, 0x3C, 0x22, 0x22, 0x22, 0x3C, 0x00, 0x00, /* b */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1C, 0x20, 0x20, 0x22, 0x1C, 0x00, 0x00, /* c */
+	0x00, 0x00, 0x00, 0x02, 0x02, 0x1E, 0x22, 0x22, 0x22, 0x1E, 0x00, 0x00, /* d */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1C, 0x22, 0x3E, 0x20, 0x1C, 0x00, 0x00, /* e */
+	0x00, 0x00, 0x00, 0x0C, 0x12, 0x10, 0x38, 0x10, 0x10, 0x10, 0x00, 0x00, /* f */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1E, 0x22, 0x22, 0x1E, 0x02, 0x1C, 0x00, /* g */
+	0x00, 0x00, 0x00, 0x20, 0x20, 0x2C, 0x32, 0x22, 0x22, 0x22, 0x00, 0x00, /* h */
+	0x00, 0x00, 0x00, 0x08, 0x00, 0x18, 0x08, 0x08, 0x08, 0x1C, 0x00, 0x00, /* i */
+	0x00, 0x00, 0x00, 0x04, 0x00, 0x0C, 0x04, 0x04, 0x04, 0x24, 0x18, 0x00, /* j */
+	0x00, 0x00, 0x00, 0x20, 0x20, 0x24, 0x28, 0x30, 0x28, 0x24, 0x00, 0x00, /* k */
+	0x00, 0x00, 0x00, 0x18, 0x08, 0x08, 0x08, 0x08, 0x08, 0x1C, 0x00, 0x00, /* l */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x34, 0x2A, 0x2A, 0x2A, 0x2A, 0x00, 0x00, /* m */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x2C, 0x32, 0x22, 0x22, 0x22, 0x00, 0x00, /* n */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1C, 0x22, 0x22, 0x22, 0x1C, 0x00, 0x00, /* o */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x3C, 0x22, 0x22, 0x3C, 0x20, 0x20, 0x00, /* p */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1E, 0x22, 0x22, 0x1E, 0x02, 0x02, 0x00, /* q */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x2C, 0x32, 0x20, 0x20, 0x20, 0x00, 0x00, /* r */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x1E, 0x20, 0x1C, 0x02, 0x3C, 0x00, 0x00, /* s */
+	0x00, 0x00, 0x00, 0x10, 0x10, 0x38, 0x10, 0x10, 0x12, 0x0C, 0x00, 0x00, /* t */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x22, 0x22, 0x26, 0x1A, 0x00, 0x00, /* u */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x22, 0x22, 0x14, 0x08, 0x00, 0x00, /* v */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x22, 0x2A, 0x2A, 0x14, 0x00, 0x00, /* w */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x14, 0x08, 0x14, 0x22, 0x00, 0x00, /* x */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x22, 0x22, 0x22, 0x1E, 0x02, 0x1C, 0x00, /* y */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x3E, 0x04, 0x08, 0x10, 0x3E, 0x00, 0x00, /* z */
+	0x00, 0x00, 0x00, 0x0C, 0x10, 0x10, 0x20, 0x10, 0x10, 0x0C, 0x00, 0x00, /* { */
+	0x00, 0x00, 0x00, 0x08, 0x08, 0x08, 0x08, 0x08, 0x08, 0x08, 0x00, 0x00, /* | */
+	0x00, 0x00, 0x00, 0x18, 0x04, 0x04, 0x02, 0x04, 0x04, 0x18, 0x00, 0x00, /* } */
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x2A, 0x04, 0x00, 0x00, 0x00, 0x00, /* ~ */
+	0x00, 0x00, 0x00, 0x2A, 0x14, 0x2A, 0x14, 0x2A, 0x14, 0x2A, 0x00, 0x00, /* DEL */
+}
+
 func mc6847getFontLine(ch uint8, row int) uint8 {
 	return mc6847_fontdata8x12[int(ch)*12+row]
 }
+
+type FontVariant int
+
+const (
+	FontMC6847 FontVariant = iota
+	FontMC6847T1
+)
+
+/*
+The INT/EXT pin selects the internal font or an external character
+generator for the alphanumeric modes. The usual lower case mod for the
+Atom connects INT/EXT to D6, replacing the semigraphics characters with
+the glyphs of the external ROM.
+*/
+type IntExtMode int
+
+const (
+	IntExtInternal IntExtMode = iota // INT/EXT is low, the stock Atom
+	IntExtExternal                   // INT/EXT is high
+	IntExtBit6                       // INT/EXT is connected to D6
+)
+
+const charGenCellSize = 12 // 8*12 cells, a byte per row
+
+func checkCharGen(data []uint8) error {
+	if len(data) < 64*charGenCellSize || len(data)%charGenCellSize != 0 {
+		return fmt.Errorf("character generator of %v bytes, expected 8x12 cells for at least 64 characters", len(data))
+	}
+	return nil
+}
+
+func (mc *mc6847) loadCharGen(data []uint8, mode IntExtMode) {
+	mc.extFont = data
+	mc.intExt = mode
+}
+
+func (mc *mc6847) isExternalChar(ch uint8) bool {
+	if mc.extFont == nil {
+		return false
+	}
+	switch mc.intExt {
+	case IntExtExternal:
+		return true
+	case IntExtBit6:
+		return ch&0x40 != 0
+	}
+	return false
+}
+
+// Returns the pixels of a row of a character and if they are to be inverted.
+func (mc *mc6847) fontLine(ch uint8, row int) (uint8, bool) {
+	inverse := ch&0x80 != 0 // Bit 7
+	if mc.isExternalChar(ch) {
+		glyphs := len(mc.extFont) / charGenCellSize
+		index := int(ch&0x7f) % glyphs
+		return mc.extFont[index*charGenCellSize+row], inverse
+	}
+	if mc.fontVariant == FontMC6847T1 && inverse && ch&0x20 == 0 {
+		return mc6847t1_lowercase8x12[int(ch&0x1f)*charGenCellSize+row], false
+	}
+	return mc6847getFontLine(ch&0x3f, row), inverse
+}
@@ -0,0 +1,227 @@
+package izatom
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Memory map of the Atom. Each device registers the address range it
+decodes, the areas added later take precedence over the earlier ones.
+The lookup is by 256 byte pages, as all the Atom devices decode whole
+pages.
+
+The devices do not decode all the address lines, the mask has the bits
+used and the rest of the range are mirrors. The stock map is:
+
+	#0000-#09FF RAM
+	#0A00-#0AFF 8271 FDC, mask #0007
+	#0B00-#7FFF RAM
+	#8000-#97FF Video RAM
+	#9800-#9FFF RAM
+	#A000-#AFFF Utility ROM
+	#B000-#B7FF 8255 PPIA, mask #0003
+	#B800-#BFFF 6522 VIA, mask #000F
+	#C000-#CFFF BASIC ROM
+	#D000-#DFFF Floating point ROM
+	#E000-#EFFF DOS ROM
+	#F000-#FFFF Kernel ROM
+*/
+
+type memoryArea struct {
+	name  string
+	start uint16
+	end   uint16 // Inclusive
+	mask  uint16 // Bits of the offset decoded by the device
+	read  func(offset uint16) uint8
+	peek  func(offset uint16) uint8        // Read without side effects
+	write func(offset uint16, value uint8) // nil if read only
+}
+
+type memoryMap struct {
+	pages [256]*memoryArea
+}
+
+func (area *memoryArea) check() error {
+	if area.start&0xff != 0 || area.end&0xff != 0xff || area.end < area.start {
+		return fmt.Errorf("the area #%04X-#%04X for %v is not made of whole pages",
+			area.start, area.end, area.name)
+	}
+	return nil
+}
+
+func (m *memoryMap) add(area *memoryArea) error {
+	err := area.check()
+	if err != nil {
+		return err
+	}
+	if area.peek == nil {
+		area.peek = area.read
+	}
+	for page := int(area.start >> 8); page <= int(area.end>>8); page++ {
+		m.pages[page] = area
+	}
+	return nil
+}
+
+func (m *memoryMap) lookup(address uint16) (*memoryArea, uint16) {
+	area := m.pages[address>>8]
+	if area == nil {
+		return nil, 0
+	}
+	return area, (address - area.start) & area.mask
+}
+
+func (m *memoryMap) read(address uint16) uint8 {
+	area, offset := m.lookup(address)
+	if area == nil {
+		return 0 // Unmapped
+	}
+	return area.read(offset)
+}
+
+func (m *memoryMap) peek(address uint16) uint8 {
+	area, offset := m.lookup(address)
+	if area == nil {
+		return 0 // Unmapped
+	}
+	return area.peek(offset)
+}
+
+func (m *memoryMap) write(address uint16, value uint8) {
+	area, offset := m.lookup(address)
+	if area == nil || area.write == nil {
+		return // Unmapped or read only
+	}
+	area.write(offset, value)
+}
+
+func (m *memoryMap) String() string {
+	var sb strings.Builder
+	for page := 0; page < 256; {
+		area := m.pages[page]
+		end := page
+		for end+1 < 256 && m.pages[end+1] == area {
+			end++
+		}
+		fmt.Fprintf(&sb, "#%02X00-#%02XFF ", page, end)
+		if area == nil {
+			sb.WriteString("unmapped")
+		} else {
+			sb.WriteString(area.name)
+			if area.mask < area.end-area.start {
+				fmt.Fprintf(&sb, ", mask #%04X", area.mask)
+			}
+			if area.write == nil {
+				sb.WriteString(", read only")
+			}
+		}
+		sb.WriteString("\n")
+		page = end + 1
+	}
+	return sb.String()
+}
+
+// MemoryMap returns a description of the current memory map, a line per area
+func (a *Atom) MemoryMap() string {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	return a.memory.String()
+}
+
+func ramArea(name string, ram []uint8, start uint16, end uint16) *memoryArea {
+	return &memoryArea{
+		name:  name,
+		start: start,
+		end:   end,
+		mask:  0xffff, // Not mirrored
+		read: func(offset uint16) uint8 {
+			return ram[start+offset]
+		},
+		write: func(offset uint16, value uint8) {
+			ram[start+offset] = value
+		},
+	}
+}
+
+func romArea(name string, rom []uint8, start uint16, end uint16) *memoryArea {
+	return &memoryArea{
+		name:  name,
+		start: start,
+		end:   end,
+		mask:  0xffff, // Not mirrored
+		read: func(offset uint16) uint8 {
+			return rom[offset]
+		},
+	}
+}
+
+func (a *Atom) mapMemory() error {
+	areas := []*memoryArea{
+		ramArea("RAM", a.ram[:], 0x0000, 0x9fff),
+		ramArea("Video RAM", a.ram[:], videoMemoryStart, videoMemoryStart+videoMemorySize-1),
+		{
+			name:  "8271 FDC",
+			start: fdcStart,
+			end:   fdcStart + 0xff,
+			mask:  0x07, // 3 bits used
+			read: func(offset uint16) uint8 {
+				return a.fdc.read(uint8(offset))
+			},
+			peek: func(offset uint16) uint8 {
+				return a.fdc.peek(uint8(offset))
+			},
+			write: func(offset uint16, value uint8) {
+				a.fdc.write(uint8(offset), value)
+			},
+		},
+		{
+			name:  "8255 PPIA",
+			start: ppiaStart,
+			end:   ppiaStart + 0x7ff,
+			mask:  0x03, // 2 bits used
+			read: func(offset uint16) uint8 {
+				value := a.ppia.read(uint8(offset))
+				//a.logf("[PPIA] Read: PPIA port%c = 0x%02x\n", 'A'+offset, value)
+				return value
+			},
+			peek: func(offset uint16) uint8 {
+				return a.ppia.peek(uint8(offset))
+			},
+			write: func(offset uint16, value uint8) {
+				//a.logf("[PPIA] Write: PPIA port%c = 0x%02x - %08b\n", 'A'+offset, value, value)
+				a.ppia.write(uint8(offset), value)
+			},
+		},
+		{
+			name:  "6522 VIA",
+			start: viaStart,
+			end:   viaStart + 0x7ff,
+			mask:  0x0f, // 4 bits used
+			read: func(offset uint16) uint8 {
+				value := a.via.read(uint8(offset))
+				a.logf("[VIA] Read: VIA register %x = 0x%02x\n", offset, value)
+				return value
+			},
+			peek: func(offset uint16) uint8 {
+				return a.via.peek(uint8(offset))
+			},
+			write: func(offset uint16, value uint8) {
+				a.logf("[VIA] Write: VIA register %x = 0x%02x - %08b\n", offset, value, value)
+				a.via.write(uint8(offset), value)
+			},
+		},
+	}
+	for _, rom := range romSlots {
+		areas = append(areas, romArea(rom.description, a.rom[rom.address-romStart:],
+			rom.address, rom.address+0xfff))
+	}
+
+	for _, area := range areas {
+		err := a.memory.add(area)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package izatom
+
+import "testing"
+
+func TestDeviceMirrors(t *testing.T) {
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrors := []struct {
+		name     string
+		register uint16
+		mirrors  []uint16
+	}{
+		{"6522 DDRB", viaStart + VIA6522_DDRB, []uint16{0xb812, 0xbbf2, 0xbc02, 0xbff2}},
+		{"8255 port A", ppiaStart + INS8255_PORT_A, []uint16{0xb004, 0xb400, 0xb7fc}},
+	}
+	for _, m := range mirrors {
+		for i, mirror := range m.mirrors {
+			value := uint8(0x10 + i)
+			a.Poke(mirror, value)
+			if v := a.Peek(m.register); v != value {
+				t.Errorf("%v is #%02X after writing #%02X on #%04X", m.name, v, value, mirror)
+			}
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package izatom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+Movies, the inputs of a session recorded with their cycle to replay it
+later with the same execution. The keys, BREAK for the resets included,
+the joystick buttons, the disks inserted, with their image, and the
+write protect changes are recorded. Every some frames a checksum of the
+RAM and the CPU registers is added, the playback compares it to detect
+desyncs.
+
+The movies start with the Atom, before executing the emulation for the
+first time, and are to be played with the same configuration: video
+standard, character ROM, font and joystick. The disks of the playback
+are loaded from the movie and their changes are not saved.
+
+The file has the signature "IZMOVIE1" and the frames between checksums,
+followed by the records, each with the type and the cycle:
+	'K' Key: the code and 1 if released
+	'J' Joystick button: the code and 1 if released
+	'D' Disk: the drive, 1 if write protected, the path and the image
+	'P' Write protect: the drive and 1 if protected
+	'C' Checksum: the frame and the CRC32
+	'E' End of the movie
+The numbers are big endian: the cycles and frames of 64 bits, the codes,
+drives and flags of 8 bits and the CRC of 32 bits. The path and the image
+are preceded by their length, of 16 and 32 bits.
+*/
+
+const (
+	movieSignature = "IZMOVIE1"
+
+	movieKey      uint8 = 'K'
+	movieJoystick uint8 = 'J'
+	movieDisk     uint8 = 'D'
+	movieProtect  uint8 = 'P'
+	movieChecksum uint8 = 'C'
+	movieEnd      uint8 = 'E'
+
+	// DefaultChecksumFrames is a second of frames, for RecordMovie
+	DefaultChecksumFrames = 60
+)
+
+type movie struct {
+	recording      bool
+	playing        bool
+	file           *os.File
+	writer         *bufio.Writer
+	checksumFrames uint64
+	checksums      []expectedChecksum // Pending on playback
+
+	errMutex sync.Mutex
+	err      error // The first error writing or desync
+}
+
+type expectedChecksum struct {
+	frame uint64
+	value uint32
+}
+
+/*
+RecordMovie starts recording the inputs on a movie file, with a checksum
+every checksumFrames frames. It has to be called before executing the
+emulation, after the disks are loaded. StopMovie ends the recording.
+*/
+func (a *Atom) RecordMovie(path string, checksumFrames int) error {
+	if checksumFrames <= 0 {
+		return errors.New("the frames between checksums have to be positive")
+	}
+
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	err := a.canStartMovie()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	m := &a.movie
+	m.recording = true
+	m.file = file
+	m.writer = bufio.NewWriter(file)
+	m.checksumFrames = uint64(checksumFrames)
+	m.writer.WriteString(movieSignature)
+	binary.Write(m.writer, binary.BigEndian, uint32(checksumFrames))
+	for i := range a.fdc.drives {
+		m.recordDisk(0, i, &a.fdc.drives[i])
+	}
+	return nil
+}
+
+/*
+PlayMovie schedules the inputs of a movie file. It has to be called
+before executing the emulation. While playing, the keys and buttons sent
+are ignored. MovieError returns the desyncs found.
+*/
+func (a *Atom) PlayMovie(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	err = a.canStartMovie()
+	if err != nil {
+		return err
+	}
+	inputs := append([]scheduledInput(nil), a.inputs...)
+	err = a.scheduleMovie(data)
+	if err != nil {
+		a.inputs = inputs
+		a.movie.checksums = nil
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	a.movie.playing = true
+	return nil
+}
+
+/*
+StopMovie ends the recording or the playback. It returns the first error
+writing the movie or the first desync of the playback.
+*/
+func (a *Atom) StopMovie() error {
+	return a.configureWait(func() error {
+		m := &a.movie
+		if m.recording {
+			m.write(movieEnd, a.cpu.GetCycles())
+			m.fail(m.writer.Flush())
+			m.fail(m.file.Close())
+			m.recording = false
+		}
+		m.playing = false
+		m.checksums = nil
+
+		m.errMutex.Lock()
+		defer m.errMutex.Unlock()
+		err := m.err
+		m.err = nil
+		return err
+	})
+}
+
+// MovieError returns the first error writing the movie or desync of the playback
+func (a *Atom) MovieError() error {
+	a.movie.errMutex.Lock()
+	defer a.movie.errMutex.Unlock()
+	return a.movie.err
+}
+
+func (a *Atom) canStartMovie() error {
+	if a.started || a.running {
+		return errors.New("the movies have to start before the emulation")
+	}
+	if a.movie.recording || a.movie.playing {
+		return errors.New("there is already a movie in progress")
+	}
+	return nil
+}
+
+func (a *Atom) scheduleMovie(data []uint8) error {
+	r := bytes.NewReader(data)
+	signature := make([]uint8, len(movieSignature))
+	var checksumFrames uint32
+	if _, err := r.Read(signature); err != nil || string(signature) != movieSignature {
+		return errors.New("not a movie file")
+	}
+	if err := binary.Read(r, binary.BigEndian, &checksumFrames); err != nil {
+		return err
+	}
+
+	for {
+		var kind uint8
+		var cycle uint64
+		if err := binary.Read(r, binary.BigEndian, &kind); err != nil {
+			return errors.New("the movie has no end")
+		}
+		if err := binary.Read(r, binary.BigEndian, &cycle); err != nil {
+			return err
+		}
+
+		switch kind {
+		case movieKey, movieJoystick, movieProtect:
+			var values [2]uint8
+			if err := binary.Read(r, binary.BigEndian, &values); err != nil {
+				return err
+			}
+			code, flag := int(values[0]), values[1] != 0
+			switch {
+			case kind == movieKey && code < KEY_NONE:
+				a.schedulePlayback(cycle, func() { a.pressKey(code, flag) })
+			case kind == movieJoystick && code < JOYSTICK_SIZE:
+				a.schedulePlayback(cycle, func() { a.pressButton(code, flag) })
+			case kind == movieProtect && code < len(a.fdc.drives):
+				a.schedulePlayback(cycle, func() { a.setWriteProtect(code, flag) })
+			default:
+				return fmt.Errorf("invalid code %v on record %c", code, kind)
+			}
+
+		case movieDisk:
+			disk, options, path, err := readMovieDisk(r)
+			if err != nil {
+				return err
+			}
+			if options.Drive >= len(a.fdc.drives) {
+				return fmt.Errorf("there is no drive %v", options.Drive)
+			}
+			a.schedulePlayback(cycle, func() {
+				err := a.insertDisk(path, options, disk, nil)
+				if err != nil {
+					a.movie.fail(err)
+					return
+				}
+				a.fdc.drives[options.Drive].inMemory = true
+			})
+
+		case movieChecksum:
+			var c expectedChecksum
+			if err := binary.Read(r, binary.BigEndian, &c.frame); err != nil {
+				return err
+			}
+			if err := binary.Read(r, binary.BigEndian, &c.value); err != nil {
+				return err
+			}
+			a.movie.checksums = append(a.movie.checksums, c)
+
+		case movieEnd:
+			a.schedulePlayback(cycle, func() {
+				a.movie.playing = false
+			})
+			return nil
+
+		default:
+			return fmt.Errorf("unknown record %v", kind)
+		}
+	}
+}
+
+func readMovieDisk(r io.Reader) (floppyDisk, DiskOptions, string, error) {
+	var options DiskOptions
+	var values [2]uint8
+	var pathLength uint16
+	var imageLength uint32
+	if err := binary.Read(r, binary.BigEndian, &values); err != nil {
+		return nil, options, "", err
+	}
+	options.Drive = int(values[0])
+	options.WriteProtect = values[1] != 0
+	if err := binary.Read(r, binary.BigEndian, &pathLength); err != nil {
+		return nil, options, "", err
+	}
+	path := make([]uint8, pathLength)
+	if _, err := io.ReadFull(r, path); err != nil {
+		return nil, options, "", err
+	}
+	if err := binary.Read(r, binary.BigEndian, &imageLength); err != nil {
+		return nil, options, "", err
+	}
+	image := make([]uint8, imageLength)
+	if _, err := io.ReadFull(r, image); err != nil {
+		return nil, options, "", err
+	}
+	disk, err := newFloppyDisk(string(path), image)
+	return disk, options, string(path), err
+}
+
+// The inputs of a playback are dropped if it is stopped
+func (a *Atom) schedulePlayback(cycle uint64, apply func()) {
+	a.schedule(cycle, func() {
+		if a.movie.playing {
+			apply()
+		}
+	})
+}
+
+// The live inputs are ignored while playing a movie
+func (a *Atom) liveKey(key int, released bool) {
+	if !a.movie.playing {
+		a.pressKey(key, released)
+	}
+}
+
+func (a *Atom) liveButton(button int, released bool) {
+	if !a.movie.playing {
+		a.pressButton(button, released)
+	}
+}
+
+// Records or verifies the checksum at the end of a frame
+func (a *Atom) movieFrame() {
+	m := &a.movie
+	if m.recording && a.frame%m.checksumFrames == 0 {
+		m.write(movieChecksum, a.cpu.GetCycles(), a.frame, a.checksum())
+	}
+	if m.playing {
+		for len(m.checksums) > 0 && m.checksums[0].frame <= a.frame {
+			expected := m.checksums[0]
+			m.checksums = m.checksums[1:]
+			if expected.frame != a.frame {
+				m.fail(fmt.Errorf("the movie desynced, there is no frame %v", expected.frame))
+			} else if expected.value != a.checksum() {
+				m.fail(fmt.Errorf("the movie desynced on frame %v", a.frame))
+			}
+		}
+	}
+}
+
+// CRC32 of the RAM and the CPU registers
+func (a *Atom) checksum() uint32 {
+	h := crc32.NewIEEE()
+	h.Write(a.ram[:])
+	a.cpu.Save(h)
+	return h.Sum32()
+}
+
+func (m *movie) recordInput(kind uint8, cycle uint64, code int, flag bool) {
+	m.write(kind, cycle, [2]uint8{uint8(code), boolByte(flag)})
+}
+
+func (m *movie) recordDisk(cycle uint64, drive int, d *floppyDrive) {
+	if d.disk == nil {
+		return
+	}
+	image := d.disk.image()
+	m.write(movieDisk, cycle, [2]uint8{uint8(drive), boolByte(d.writeProtect)},
+		uint16(len(d.path)), []uint8(d.path), uint32(len(image)), image)
+}
+
+// Writes a record if recording. The errors are kept by the bufio.Writer.
+func (m *movie) write(kind uint8, cycle uint64, values ...interface{}) {
+	if !m.recording {
+		return
+	}
+	binary.Write(m.writer, binary.BigEndian, kind)
+	binary.Write(m.writer, binary.BigEndian, cycle)
+	for _, v := range values {
+		binary.Write(m.writer, binary.BigEndian, v)
+	}
+}
+
+func (m *movie) fail(err error) {
+	m.errMutex.Lock()
+	defer m.errMutex.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
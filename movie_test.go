@@ -0,0 +1,201 @@
+package izatom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const movieTestChecksumFrames = 10
+
+// Records a session with a disk inserted after the boot, returns the Atom at the end
+func recordMovie(t *testing.T, path string) *Atom {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.RecordMovie(path, movieTestChecksumFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, a, bootFrames)
+	err = a.LoadDiskWithOptions(blankImage(t), DiskOptions{WriteProtect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeLines(t, a, "*DOS", "*CAT")
+	err = a.StopMovie()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Plays the movie up to the frames of the recorded session
+func playMovie(t *testing.T, path string, frames uint64) *Atom {
+	t.Helper()
+	a, err := NewAtom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.PlayMovie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.RunFrames(int(frames))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Returns the offsets of the records of a kind on a movie file
+func movieRecords(t *testing.T, data []uint8, kind uint8) []int {
+	t.Helper()
+	var offsets []int
+	offset := len(movieSignature) + 4
+	for offset < len(data) {
+		k := data[offset]
+		if k == kind {
+			offsets = append(offsets, offset)
+		}
+		next := offset + 1 + 8
+		switch k {
+		case movieKey, movieJoystick, movieProtect:
+			next += 2
+		case movieChecksum:
+			next += 8 + 4
+		case movieDisk:
+			next += 2
+			next += 2 + int(binary.BigEndian.Uint16(data[next:]))
+			next += 4 + int(binary.BigEndian.Uint32(data[next:]))
+		case movieEnd:
+			return offsets
+		default:
+			t.Fatalf("unknown record %c at %v", k, offset)
+		}
+		offset = next
+	}
+	t.Fatal("the movie has no end")
+	return nil
+}
+
+func TestMovieReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.izm")
+	recorded := recordMovie(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movieRecords(t, data, movieDisk)) != 1 {
+		t.Fatal("the disk inserted is not on the movie")
+	}
+	if len(movieRecords(t, data, movieChecksum)) == 0 {
+		t.Fatal("there are no checksums on the movie")
+	}
+
+	played := playMovie(t, path, recorded.frame)
+	if err := played.MovieError(); err != nil {
+		t.Fatal(err)
+	}
+	if played.Cycles() != recorded.Cycles() {
+		t.Errorf("the playback ends on cycle %v, not %v", played.Cycles(), recorded.Cycles())
+	}
+	if !bytes.Equal(played.ram[:], recorded.ram[:]) {
+		t.Error("the RAM differs from the recorded session")
+	}
+	if played.fdc.drives[0].disk == nil {
+		t.Error("the disk is not inserted on the playback")
+	}
+}
+
+func TestMovieDesync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.izm")
+	recorded := recordMovie(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first key typed is another
+	keys := movieRecords(t, data, movieKey)
+	if len(keys) == 0 {
+		t.Fatal("there are no keys on the movie")
+	}
+	data[keys[0]+1+8] = KEY_Z
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	played := playMovie(t, path, recorded.frame)
+	err = played.MovieError()
+	if err == nil || !strings.Contains(err.Error(), "desynced") {
+		t.Errorf("the error is %v, not a desync", err)
+	}
+}
+
+func TestMovieErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.izm")
+	recordMovie(t, path)
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := movieRecords(t, valid, movieKey)
+	disk := movieRecords(t, valid, movieDisk)[0]
+
+	movies := []struct {
+		name  string
+		movie []uint8
+		err   string
+	}{
+		{"no signature", append([]uint8("IZMOVIE0"), valid[8:]...), "not a movie file"},
+		{"empty", nil, "not a movie file"},
+		{"no end", valid[:len(valid)-9], "the movie has no end"},
+		{"truncated record", valid[:keys[0]+5], ""},
+		{"truncated disk", valid[:disk+100], ""},
+		{"unknown record", func() []uint8 {
+			m := append([]uint8(nil), valid...)
+			m[keys[0]] = 'X'
+			return m
+		}(), "unknown record"},
+		{"invalid key", func() []uint8 {
+			m := append([]uint8(nil), valid...)
+			m[keys[0]+1+8] = KEY_NONE
+			return m
+		}(), "invalid code"},
+	}
+	for _, m := range movies {
+		err = os.WriteFile(path, m.movie, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewAtom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.PlayMovie(path)
+		if err == nil || !strings.Contains(err.Error(), m.err) {
+			t.Errorf("%v: the error is %v", m.name, err)
+		}
+		if len(a.inputs) != 0 || a.movie.playing {
+			t.Errorf("%v: the movie is partially scheduled", m.name)
+		}
+	}
+}
+
+func TestMovieAfterStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.izm")
+	a := bootAtom(t)
+	if err := a.RecordMovie(path, DefaultChecksumFrames); err == nil {
+		t.Error("recording after the emulation started")
+	}
+	if err := a.PlayMovie(path); err == nil {
+		t.Error("playing after the emulation started")
+	}
+}
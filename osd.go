@@ -0,0 +1,50 @@
+package izatom
+
+import (
+	"image"
+	"image/color"
+)
+
+/*
+Helpers for the frontends to draw messages over the Atom screen with
+the MC6847 internal font.
+*/
+
+const (
+	CharWidth  = 8
+	CharHeight = 12
+)
+
+// DrawText draws text on the image with 8x12 characters. Lower case is shown as upper case.
+func DrawText(img *image.RGBA, at image.Point, text string, fg color.Color, bg color.Color) {
+	x := at.X
+	for _, ch := range text {
+		code := charToCode(ch)
+		for row := 0; row < CharHeight; row++ {
+			pixels := mc6847getFontLine(code, row)
+			for col := 0; col < CharWidth; col++ {
+				c := bg
+				if pixels&(0x80>>col) != 0 {
+					c = fg
+				}
+				img.Set(x+col, at.Y+row, c)
+			}
+		}
+		x += CharWidth
+	}
+}
+
+// Returns the code on the internal font of a character
+func charToCode(ch rune) uint8 {
+	if ch >= 'a' && ch <= 'z' {
+		ch -= 'a' - 'A'
+	}
+	switch {
+	case ch >= 0x20 && ch < 0x40:
+		return uint8(ch)
+	case ch >= 0x40 && ch < 0x60:
+		return uint8(ch - 0x40)
+	default:
+		return '?'
+	}
+}
@@ -0,0 +1,68 @@
+package izatom
+
+/*
+Concurrency model. While Run is executing, only the emulation goroutine
+touches the machine, the same for RunCycles and RunFrames on the calling
+goroutine. At the end of each frame it publishes the video
+state, the IRQ sources and the drives status. Snapshot, VideoMode,
+VideoMemory, InterruptSources and DriveStatus read from that copy.
+
+The configuration changes requested from other goroutines are queued and
+applied at the next frame boundary, or right away if Run is not
+executing. The keys and the joystick go through channels, or are
+scheduled at a cycle, see deterministic.go. The speed control is atomic.
+*/
+
+// Applies the change now or on the next frame if running
+func (a *Atom) configure(change func()) {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	a.configureLocked(change)
+}
+
+// Applies the change and waits for it. Not to be called from the frame listener.
+func (a *Atom) configureWait(change func() error) error {
+	done := make(chan error, 1)
+	a.configure(func() {
+		done <- change()
+	})
+	return <-done
+}
+
+func (a *Atom) configureLocked(change func()) {
+	if a.running {
+		a.changes = append(a.changes, change)
+		return
+	}
+	change()
+	a.capture()
+}
+
+func (a *Atom) setRunning(running bool) {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	a.running = running
+	a.applyChanges()
+	a.capture()
+}
+
+// Called from the emulation goroutine on the frame boundaries
+func (a *Atom) publish() {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	a.applyChanges()
+	a.capture()
+}
+
+func (a *Atom) capture() {
+	a.vdu.capture(&a.published)
+	a.captureIRQ()
+	a.captureDrives()
+}
+
+func (a *Atom) applyChanges() {
+	for _, change := range a.changes {
+		change()
+	}
+	a.changes = nil
+}
@@ -0,0 +1,118 @@
+package izatom
+
+import "strings"
+
+/*
+The alphanumeric screen as text, for the assertions of the tests and
+for the text frontends. The 32*16 cells are the first 512 bytes of the
+video memory at #8000, one 6847 code each:
+	Bit 7: inverse video, dark on light
+	Bit 6: semigraphics 6, with the 2*3 blocks on bits 5 to 0
+	Bits 5-0: the character, 0x00-0x1f are "@A-Z[\]^_" and 0x20-0x3f
+	are " !"#..?" as in ASCII
+The decoding is for the stock Atom, with the internal font. An external
+character generator or the MC6847T1 font show other glyphs for some
+codes.
+*/
+
+const (
+	ScreenColumns = 32
+	ScreenLines   = 16
+)
+
+// ScreenCell is a 6847 code decoded
+type ScreenCell struct {
+	Code        uint8 // As on the video memory
+	Char        rune  // ASCII, or the Unicode sextant for the semigraphics
+	Inverse     bool  // For the semigraphics, red instead of yellow
+	Semigraphic bool
+	Blocks      uint8 // The semigraphics blocks lit, top left is bit 0 and bottom right bit 5
+}
+
+// DecodeScreenCode decodes a character code of the alphanumeric mode
+func DecodeScreenCode(code uint8) ScreenCell {
+	cell := ScreenCell{
+		Code:        code,
+		Inverse:     code&0x80 != 0,
+		Semigraphic: code&0x40 != 0,
+	}
+	if cell.Semigraphic {
+		// From top left to bottom right the bits are 5 to 0
+		for i := 0; i < 6; i++ {
+			if code&(0x20>>i) != 0 {
+				cell.Blocks |= 1 << i
+			}
+		}
+		cell.Char = Sextant(cell.Blocks)
+	} else {
+		ch := code & 0x3f
+		if ch < 0x20 {
+			ch += 0x40
+		}
+		cell.Char = rune(ch)
+	}
+	return cell
+}
+
+// ScreenCells decodes the alphanumeric screen on the video memory, by lines
+func ScreenCells(vram []uint8) [ScreenLines][ScreenColumns]ScreenCell {
+	var cells [ScreenLines][ScreenColumns]ScreenCell
+	for line := 0; line < ScreenLines; line++ {
+		for col := 0; col < ScreenColumns; col++ {
+			cells[line][col] = DecodeScreenCode(vram[line*ScreenColumns+col])
+		}
+	}
+	return cells
+}
+
+/*
+ScreenText returns the lines of the alphanumeric screen on the video
+memory, without the trailing spaces. The inverse characters are returned
+as the normal ones and the semigraphics as sextants, an empty one is a
+space. Only meaningful when the alphanumeric mode is selected.
+*/
+func ScreenText(vram []uint8) []string {
+	cells := ScreenCells(vram)
+	lines := make([]string, ScreenLines)
+	for i, line := range cells {
+		var sb strings.Builder
+		for _, cell := range line {
+			sb.WriteRune(cell.Char)
+		}
+		lines[i] = strings.TrimRight(sb.String(), " ")
+	}
+	return lines
+}
+
+// ScreenText returns the lines of the screen on the last frame, see the ScreenText function
+func (a *Atom) ScreenText() []string {
+	return ScreenText(a.VideoMemory())
+}
+
+/*
+Sextant returns the character with the 2*3 blocks lit, top left is bit 0
+and bottom right bit 5. The sextants on "Symbols for Legacy Computing"
+start at U+1FB00, without the empty, full, left half and right half
+blocks, that are on other Unicode blocks.
+*/
+func Sextant(blocks uint8) rune {
+	blocks &= 0x3f
+	switch blocks {
+	case 0:
+		return ' '
+	case 0x15:
+		return '▌'
+	case 0x2a:
+		return '▐'
+	case 0x3f:
+		return '█'
+	}
+	r := 0x1fb00 + rune(blocks) - 1
+	if blocks > 0x15 {
+		r--
+	}
+	if blocks > 0x2a {
+		r--
+	}
+	return r
+}
@@ -0,0 +1,62 @@
+package izatom
+
+import "testing"
+
+func TestDecodeScreenCode(t *testing.T) {
+	codes := []struct {
+		code uint8
+		cell ScreenCell
+	}{
+		{0x00, ScreenCell{Char: '@'}},
+		{0x01, ScreenCell{Char: 'A'}},
+		{0x1f, ScreenCell{Char: '_'}},
+		{0x20, ScreenCell{Char: ' '}},
+		{0x3f, ScreenCell{Char: '?'}},
+		{0x81, ScreenCell{Char: 'A', Inverse: true}},
+		{0xa0, ScreenCell{Char: ' ', Inverse: true}},
+		{0x40, ScreenCell{Char: ' ', Semigraphic: true}},
+		{0x60, ScreenCell{Char: '\U0001fb00', Semigraphic: true, Blocks: 0x01}},
+		{0x41, ScreenCell{Char: '\U0001fb1e', Semigraphic: true, Blocks: 0x20}},
+		{0x7f, ScreenCell{Char: '█', Semigraphic: true, Blocks: 0x3f}},
+		{0xea, ScreenCell{Char: '▌', Inverse: true, Semigraphic: true, Blocks: 0x15}},
+	}
+	for _, c := range codes {
+		c.cell.Code = c.code
+		if cell := DecodeScreenCode(c.code); cell != c.cell {
+			t.Errorf("0x%02x is decoded as %+v, not %+v", c.code, cell, c.cell)
+		}
+	}
+}
+
+func TestSextantsAreDistinct(t *testing.T) {
+	found := map[rune]uint8{}
+	for blocks := uint8(0); blocks < 64; blocks++ {
+		r := Sextant(blocks)
+		if previous, ok := found[r]; ok {
+			t.Errorf("the blocks 0x%02x and 0x%02x are both %q", previous, blocks, r)
+		}
+		found[r] = blocks
+	}
+}
+
+func TestScreenText(t *testing.T) {
+	vram := make([]uint8, videoMemorySize)
+	for i := range vram {
+		vram[i] = 0x20 // Space
+	}
+	copy(vram, []uint8{0x01, 0x14, 0x0f, 0x0d, 0x20, 0x80 | 0x21})
+	copy(vram[ScreenColumns:], []uint8{0x7f, 0x40, 0x40, 0x3e})
+	text := ScreenText(vram)
+	if len(text) != ScreenLines {
+		t.Fatalf("there are %v lines", len(text))
+	}
+	if text[0] != "ATOM !" {
+		t.Errorf("the first line is %q", text[0])
+	}
+	if text[1] != "█  >" {
+		t.Errorf("the second line is %q", text[1])
+	}
+	if text[2] != "" {
+		t.Errorf("the third line is %q, not empty", text[2])
+	}
+}
@@ -0,0 +1,61 @@
+package izatom
+
+/*
+The speaker is driven by PC2 of the 8255. We keep the cycles of the
+level changes and build the samples at the end of each frame. Samples
+are taken at fixed cycle positions to get the same audio on every run.
+*/
+
+const (
+	AudioSampleRate = 44100
+	speakerVolume   = 0x2000
+)
+
+type speaker struct {
+	level       bool
+	changes     []uint64
+	nextSample  uint64 // Index of the next sample to generate
+	samplesBuff []int16
+}
+
+func newSpeaker() *speaker {
+	return &speaker{}
+}
+
+func (s *speaker) setLevel(level bool, cycle uint64) {
+	if level != s.level {
+		s.level = level
+		s.changes = append(s.changes, cycle)
+	}
+}
+
+func sampleCycle(sample uint64) uint64 {
+	return sample * cpuCyclesPerSecond / AudioSampleRate
+}
+
+// Returns the samples up to the cycle provided. The slice is reused on the next call.
+func (s *speaker) samples(cycle uint64) []int16 {
+	samples := s.samplesBuff[:0]
+
+	// The level at the start of the frame is the opposite of the first change
+	level := s.level
+	if len(s.changes)%2 == 1 {
+		level = !level
+	}
+	change := 0
+	for ; sampleCycle(s.nextSample) < cycle; s.nextSample++ {
+		at := sampleCycle(s.nextSample)
+		for change < len(s.changes) && s.changes[change] <= at {
+			level = !level
+			change++
+		}
+		if level {
+			samples = append(samples, speakerVolume)
+		} else {
+			samples = append(samples, -speakerVolume)
+		}
+	}
+	s.changes = s.changes[:0]
+	s.samplesBuff = samples
+	return samples
+}
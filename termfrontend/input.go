@@ -0,0 +1,108 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ivanizag/izatom"
+)
+
+/*
+The terminal sends characters, not key presses and releases. Each
+character is typed as a press of the Atom key, with SHIFT or CTRL if
+needed, held long enough for the kernel to scan it.
+*/
+
+const (
+	keyHoldTime    = 60 * time.Millisecond
+	keyReleaseTime = 60 * time.Millisecond
+	quitChar       = 0x1d // Ctrl-]
+)
+
+type keyStroke struct {
+	key   int
+	shift bool
+	ctrl  bool
+}
+
+var escapeSequences = map[string]keyStroke{
+	"\x1b[A":   {izatom.KEY_UP_DOWN, true, false},     // Up
+	"\x1b[B":   {izatom.KEY_UP_DOWN, false, false},    // Down
+	"\x1b[C":   {izatom.KEY_LEFT_RIGHT, false, false}, // Right
+	"\x1b[D":   {izatom.KEY_LEFT_RIGHT, true, false},  // Left
+	"\x1b[2~":  {izatom.KEY_UP, false, false},         // Insert
+	"\x1b[24~": {izatom.KEY_BREAK, false, false},      // F12
+	"\x1b":     {izatom.KEY_ESC, false, false},
+}
+
+// Converts the bytes of a read from the terminal to key strokes.
+func parseInput(data []uint8) (strokes []keyStroke, quit bool) {
+	for len(data) > 0 {
+		ch := data[0]
+		switch {
+		case ch == quitChar:
+			return strokes, true
+		case ch == 0x1b:
+			matched := false
+			for sequence, stroke := range escapeSequences {
+				if len(sequence) > 1 && len(data) >= len(sequence) && string(data[:len(sequence)]) == sequence {
+					strokes = append(strokes, stroke)
+					data = data[len(sequence):]
+					matched = true
+					break
+				}
+			}
+			if !matched && len(data) > 1 && data[1] == '[' {
+				// Unknown control sequence, skip up to the final byte
+				i := 2
+				for i < len(data) && (data[i] < 0x40 || data[i] > 0x7e) {
+					i++
+				}
+				if i < len(data) {
+					i++
+				}
+				data = data[i:]
+			} else if !matched {
+				strokes = append(strokes, escapeSequences["\x1b"])
+				data = data[1:]
+			}
+			continue
+		case ch == '\r' || ch == '\n':
+			strokes = append(strokes, keyStroke{izatom.KEY_RETURN, false, false})
+		case ch == 0x7f || ch == 0x08:
+			strokes = append(strokes, keyStroke{izatom.KEY_DELETE, false, false})
+		case ch == '\t':
+			strokes = append(strokes, keyStroke{izatom.KEY_COPY, false, false})
+		case ch >= 0x01 && ch <= 0x1a:
+			key, _, _ := izatom.KeyForChar(rune('A' + ch - 1))
+			strokes = append(strokes, keyStroke{key, false, true})
+		default:
+			key, shift, ok := izatom.KeyForChar(rune(ch))
+			if ok {
+				strokes = append(strokes, keyStroke{key, shift, false})
+			}
+		}
+		data = data[1:]
+	}
+	return strokes, false
+}
+
+func typeKeys(a *izatom.Atom, strokes <-chan keyStroke) {
+	for stroke := range strokes {
+		if stroke.shift {
+			a.SendKey(izatom.KEY_LSHIFT, false)
+		}
+		if stroke.ctrl {
+			a.SendKey(izatom.KEY_CTRL, false)
+		}
+		a.SendKey(stroke.key, false)
+		time.Sleep(keyHoldTime)
+		a.SendKey(stroke.key, true)
+		if stroke.ctrl {
+			a.SendKey(izatom.KEY_CTRL, true)
+		}
+		if stroke.shift {
+			a.SendKey(izatom.KEY_LSHIFT, true)
+		}
+		time.Sleep(keyReleaseTime)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ivanizag/izatom"
+)
+
+/*
+Terminal frontend, to use the emulator without SDL, over SSH for example.
+Needs a terminal with 24 bit color and an Unicode font with the block
+sextants. Ctrl-] quits and F12 is BREAK.
+*/
+
+const refreshPeriod = time.Second / 25
+
+func main() {
+	pal := flag.Bool("pal", false, "use the PAL timing of the European Atom")
+	protect := flag.Bool("protect", false, "write protect the disk")
+	overlay := flag.Bool("overlay", false, "save the disk changes on a sidecar .overlay file, not on the image")
+	flag.Parse()
+
+	a, err := izatom.NewAtom()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *pal {
+		a.SetVideoStandard(izatom.VideoPAL)
+	}
+	if flag.NArg() > 0 {
+		err = a.LoadDiskWithOptions(flag.Arg(0), izatom.DiskOptions{
+			WriteProtect: *protect,
+			Overlay:      *overlay,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading the disk: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting the terminal in raw mode: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.WriteString("\x1b[?25l\x1b[2J") // Hide cursor, clear screen
+	defer func() {
+		os.Stdout.WriteString("\x1b[0m\x1b[?25h\r\n") // Show cursor
+		restore()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- a.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		err := <-runErr
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\r\n", err)
+		}
+	}()
+
+	strokes := make(chan keyStroke, 100)
+	go typeKeys(a, strokes)
+
+	quit := make(chan bool)
+	go func() {
+		buffer := make([]uint8, 64)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if err != nil {
+				quit <- true
+				return
+			}
+			keys, isQuit := parseInput(buffer[:n])
+			for _, k := range keys {
+				strokes <- k
+			}
+			if isQuit {
+				quit <- true
+				return
+			}
+		}
+	}()
+
+	var s screen
+	ticker := time.NewTicker(refreshPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case err := <-runErr:
+			runErr <- err // To be reported on exit
+			return
+		case <-ticker.C:
+			os.Stdout.Write(s.render(a))
+		}
+	}
+}
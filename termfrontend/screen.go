@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/ivanizag/izatom"
+)
+
+/*
+The Atom screen is shown as 32*16 character cells. On the alphanumeric
+mode the 6847 codes are converted to ASCII. The semigraphics characters
+and the graphic modes are drawn with the Unicode block sextants, each
+cell with 2*3 blocks like the 6847 semigraphics 6.
+*/
+
+const (
+	cellWidth  = 8
+	cellHeight = 12
+)
+
+var (
+	textColorLight = color.RGBA{0x30, 0xd2, 0x00, 0xff}
+	textColorDark  = color.RGBA{0x00, 0x7c, 0x00, 0xff}
+	semigraphics   = [2]color.RGBA{
+		{0xc1, 0xe5, 0x00, 0xff}, // Yellow
+		{0x9a, 0x32, 0x36, 0xff}, // Red, when inverse
+	}
+)
+
+type screen struct {
+	out        bytes.Buffer
+	foreground color.RGBA
+	background color.RGBA
+}
+
+func (s *screen) render(a *izatom.Atom) []byte {
+	s.out.Reset()
+	s.out.WriteString("\x1b[H") // Cursor home
+	s.foreground = color.RGBA{}
+	s.background = color.RGBA{}
+
+	isGraphic, _ := a.VideoMode()
+	if isGraphic {
+		s.renderGraphic(a.Snapshot())
+	} else {
+		s.renderText(a.VideoMemory())
+	}
+	s.out.WriteString("\x1b[0m")
+	return s.out.Bytes()
+}
+
+func (s *screen) renderText(vram []uint8) {
+	for _, line := range izatom.ScreenCells(vram) {
+		for _, cell := range line {
+			switch {
+			case cell.Semigraphic && cell.Inverse:
+				s.cell(cell.Char, semigraphics[1], textColorDark)
+			case cell.Semigraphic:
+				s.cell(cell.Char, semigraphics[0], textColorDark)
+			case cell.Inverse:
+				s.cell(cell.Char, textColorDark, textColorLight)
+			default:
+				s.cell(cell.Char, textColorLight, textColorDark)
+			}
+		}
+		s.newLine()
+	}
+}
+
+// A block of 4*4 pixels is lit if it has pixels brighter than the middle of the cell range.
+func (s *screen) renderGraphic(img *image.RGBA) {
+	for line := 0; line < izatom.ScreenLines; line++ {
+		for col := 0; col < izatom.ScreenColumns; col++ {
+			cell := image.Rect(col*cellWidth, line*cellHeight, (col+1)*cellWidth, (line+1)*cellHeight)
+			minLuma, maxLuma := 0x100, -1
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					l := luma(img.RGBAAt(x, y))
+					if l < minLuma {
+						minLuma = l
+					}
+					if l > maxLuma {
+						maxLuma = l
+					}
+				}
+			}
+			threshold := (minLuma + maxLuma) / 2
+
+			var blocks uint8
+			var on, off []color.RGBA
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					c := img.RGBAAt(x, y)
+					if maxLuma-minLuma > 0x10 && luma(c) > threshold {
+						block := (x-cell.Min.X)/(cellWidth/2) + 2*((y-cell.Min.Y)/(cellHeight/3))
+						blocks |= 1 << block
+						on = append(on, c)
+					} else {
+						off = append(off, c)
+					}
+				}
+			}
+			s.cell(izatom.Sextant(blocks), mixColors(on), mixColors(off))
+		}
+		s.newLine()
+	}
+}
+
+func (s *screen) cell(ch rune, foreground color.RGBA, background color.RGBA) {
+	if foreground != s.foreground {
+		fmt.Fprintf(&s.out, "\x1b[38;2;%v;%v;%vm", foreground.R, foreground.G, foreground.B)
+		s.foreground = foreground
+	}
+	if background != s.background {
+		fmt.Fprintf(&s.out, "\x1b[48;2;%v;%v;%vm", background.R, background.G, background.B)
+		s.background = background
+	}
+	s.out.WriteRune(ch)
+}
+
+func (s *screen) newLine() {
+	s.out.WriteString("\x1b[0m\r\n")
+	s.foreground = color.RGBA{}
+	s.background = color.RGBA{}
+}
+
+func mixColors(colors []color.RGBA) color.RGBA {
+	if len(colors) == 0 {
+		return color.RGBA{0, 0, 0, 0xff}
+	}
+	var r, g, b int
+	for _, c := range colors {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(colors)
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+}
+
+func luma(c color.RGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
@@ -0,0 +1,63 @@
+package izatom
+
+/*
+See the Rockwell R6522 datasheet for more information.
+
+B800-BFFF 6522 VIA, 16 registers mirrored
+
+Only the I/O ports are emulated. Port A is the printer port and port B
+is free for the user, joysticks are usually connected there. The timers,
+the shift register and the interrupts are not emulated, the registers
+are just stored.
+*/
+
+const (
+	VIA6522_ORB  = 0x0
+	VIA6522_ORA  = 0x1
+	VIA6522_DDRB = 0x2
+	VIA6522_DDRA = 0x3
+)
+
+type via6522 struct {
+	a         *Atom
+	registers [16]uint8
+}
+
+func newVIA6522(a *Atom) *via6522 {
+	return &via6522{a: a}
+}
+
+func (v *via6522) reset() {
+	// The datasheet says all registers but the timers and shift register are cleared
+	for i := 0; i < 4; i++ {
+		v.registers[i] = 0
+	}
+	for i := 0xb; i < 16; i++ {
+		v.registers[i] = 0
+	}
+}
+
+func (v *via6522) write(register uint8, value uint8) {
+	v.registers[register] = value
+}
+
+func (v *via6522) read(register uint8) uint8 {
+	switch register {
+	case VIA6522_ORB:
+		return v.readPort(v.registers[VIA6522_ORB], v.registers[VIA6522_DDRB], v.a.joystick.getPB())
+	case VIA6522_ORA, 0xf: // 0xf is ORA without handshake
+		return v.readPort(v.registers[VIA6522_ORA], v.registers[VIA6522_DDRA], 0xff)
+	default:
+		return v.registers[register]
+	}
+}
+
+// The reads have no side effects, as the interrupt flags are not emulated
+func (v *via6522) peek(register uint8) uint8 {
+	return v.read(register)
+}
+
+// The pins set as output read the output register, the inputs read the pins
+func (v *via6522) readPort(output uint8, ddr uint8, pins uint8) uint8 {
+	return (output & ddr) | (pins &^ ddr)
+}